@@ -0,0 +1,150 @@
+package cloudwatch
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+// maxMetrics is the number of metrics CloudWatch accepts in a single EMF
+// document. Larger sets of statistics are split across several documents.
+const maxMetrics = 100
+
+// DefaultNamespace is the CloudWatch namespace used when none is configured.
+const DefaultNamespace = "GoRuntime"
+
+// EMFWriter writes collected statistics to Writer as CloudWatch Embedded Metric
+// Format documents, one JSON document per line. When written to stdout on AWS
+// Lambda or ECS, or to the CloudWatch agent, the statistics become CloudWatch
+// metrics without calling the PutMetricData API. Every key returned by
+// Fields.Tags becomes a dimension. Write errors are ignored.
+//
+//	package main
+//
+//	import (
+//	   "os"
+//	   "github.com/tevjef/go-runtime-metrics/cloudwatch"
+//	   "github.com/tevjef/go-runtime-metrics/collector"
+//	)
+//
+//	func main {
+//	    emf := cloudwatch.New(os.Stdout, "MyApp", "cpu.goroutines", "mem.heap.alloc")
+//	    collector.New(emf.Log).Run()
+//	}
+type EMFWriter struct {
+	Writer io.Writer
+
+	// Namespace of the metrics. Defaults to DefaultNamespace.
+	Namespace string
+
+	// Metrics are the keys of Fields.Values that become metrics. All numeric
+	// values are written when empty.
+	Metrics []string
+}
+
+// New creates an EMFWriter writing to w. metrics limits the statistics written,
+// see EMFWriter.Metrics.
+func New(w io.Writer, namespace string, metrics ...string) *EMFWriter {
+	return &EMFWriter{
+		Writer:    w,
+		Namespace: namespace,
+		Metrics:   metrics,
+	}
+}
+
+type emfMetadata struct {
+	Timestamp         int64             `json:"Timestamp"`
+	CloudWatchMetrics []emfMetricsEntry `json:"CloudWatchMetrics"`
+}
+
+type emfMetricsEntry struct {
+	Namespace  string          `json:"Namespace"`
+	Dimensions [][]string      `json:"Dimensions"`
+	Metrics    []emfMetricName `json:"Metrics"`
+}
+
+type emfMetricName struct {
+	Name string `json:"Name"`
+}
+
+// Log writes fields as one or more EMF documents. It matches the signature of
+// collector.FieldsFunc.
+func (e *EMFWriter) Log(fields collector.Fields) {
+	values := fields.Values()
+	keys := e.metricKeys(values)
+	tags := fields.Tags()
+
+	dimensions := make([]string, 0, len(tags))
+	for k := range tags {
+		dimensions = append(dimensions, k)
+	}
+	sort.Strings(dimensions)
+
+	namespace := e.Namespace
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
+
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > maxMetrics {
+			n = maxMetrics
+		}
+
+		doc := make(map[string]interface{}, n+len(tags)+1)
+		for k, v := range tags {
+			doc[k] = v
+		}
+
+		names := make([]emfMetricName, n)
+		for i, key := range keys[:n] {
+			names[i] = emfMetricName{Name: key}
+			doc[key] = values[key]
+		}
+
+		doc["_aws"] = emfMetadata{
+			Timestamp: timestamp,
+			CloudWatchMetrics: []emfMetricsEntry{{
+				Namespace:  namespace,
+				Dimensions: [][]string{dimensions},
+				Metrics:    names,
+			}},
+		}
+
+		b, err := json.Marshal(doc)
+		if err != nil {
+			return
+		}
+
+		e.Writer.Write(append(b, '\n'))
+
+		keys = keys[n:]
+	}
+}
+
+// Sorted keys of the numeric values to write
+func (e *EMFWriter) metricKeys(values map[string]interface{}) []string {
+	keys := e.Metrics
+	if len(keys) == 0 {
+		keys = make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+	}
+
+	numeric := make([]string, 0, len(keys))
+	for _, key := range keys {
+		switch values[key].(type) {
+		case int64, float64:
+			numeric = append(numeric, key)
+		}
+	}
+	sort.Strings(numeric)
+
+	return numeric
+}
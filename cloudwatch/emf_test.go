@@ -0,0 +1,96 @@
+package cloudwatch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, "Test", "cpu.goroutines", "mem.gc.cpu_fraction", "unknown").Log(collector.New(nil).OneOff())
+
+	var doc struct {
+		AWS struct {
+			Timestamp         int64
+			CloudWatchMetrics []struct {
+				Namespace  string
+				Dimensions [][]string
+				Metrics    []struct{ Name string }
+			}
+		} `json:"_aws"`
+		Goroutines *float64 `json:"cpu.goroutines"`
+		GoOS       string   `json:"go.os"`
+	}
+
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("failed to decode EMF document: %v", err)
+	}
+
+	if len(doc.AWS.CloudWatchMetrics) != 1 {
+		t.Fatalf("expected one metrics directive, got %d", len(doc.AWS.CloudWatchMetrics))
+	}
+
+	directive := doc.AWS.CloudWatchMetrics[0]
+	if directive.Namespace != "Test" {
+		t.Errorf("expected namespace Test, got %q", directive.Namespace)
+	}
+
+	if len(directive.Metrics) != 2 || directive.Metrics[0].Name != "cpu.goroutines" {
+		t.Errorf("expected the configured metrics, got %v", directive.Metrics)
+	}
+
+	if len(directive.Dimensions) != 1 || len(directive.Dimensions[0]) == 0 {
+		t.Errorf("expected the tags as dimensions, got %v", directive.Dimensions)
+	}
+
+	if doc.Goroutines == nil || *doc.Goroutines < 1 || doc.GoOS == "" {
+		t.Errorf("expected metric and dimension values in the document, got %s", buf.String())
+	}
+}
+
+func TestLogSplitsDocuments(t *testing.T) {
+	c := collector.New(nil)
+	c.EnableRuntimeMetrics = true
+
+	fields := c.OneOff()
+
+	var buf bytes.Buffer
+	New(&buf, "").Log(fields)
+
+	total := 0
+	scanner := bufio.NewScanner(&buf)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var doc struct {
+			AWS struct {
+				CloudWatchMetrics []struct {
+					Namespace string
+					Metrics   []struct{ Name string }
+				}
+			} `json:"_aws"`
+		}
+
+		if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+			t.Fatal(err)
+		}
+
+		directive := doc.AWS.CloudWatchMetrics[0]
+		if len(directive.Metrics) > maxMetrics {
+			t.Errorf("expected at most %d metrics per document, got %d", maxMetrics, len(directive.Metrics))
+		}
+
+		if directive.Namespace != DefaultNamespace {
+			t.Errorf("expected default namespace, got %q", directive.Namespace)
+		}
+
+		total += len(directive.Metrics)
+	}
+
+	if want := len(fields.Values()); total != want {
+		t.Errorf("expected %d metrics in total, got %d", want, total)
+	}
+}
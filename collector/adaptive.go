@@ -0,0 +1,55 @@
+package collector
+
+import "time"
+
+// Factors applied to the interval by the adaptive mode
+const (
+	adaptiveSpike   = 2.0  // allocation rate relative to the average considered a spike
+	adaptiveSteady  = 1.1  // allocation rate relative to the average considered steady
+	adaptiveGrow    = 1.5  // growth of the interval while steady
+	adaptiveHistory = 0.75 // weight of the previous average allocation rate
+)
+
+// adaptiveState holds the allocation counter of the previous collection and
+// the moving average of the allocation rate used by the adaptive mode.
+type adaptiveState struct {
+	cur        time.Duration
+	at         time.Time
+	totalAlloc int64
+	avgRate    float64
+}
+
+func (c *Collector) adaptive() bool {
+	return c.MinPauseDur > 0 && c.MaxPauseDur >= c.MinPauseDur && c.EnableMem
+}
+
+// next returns the interval until the collection following fields, which was
+// gathered at now. The interval is halved when the allocation rate since the
+// previous collection spikes above the average rate and lengthened while it
+// stays at or below the average, bounded by minDur and maxDur.
+func (a *adaptiveState) next(fields *Fields, now time.Time, minDur, maxDur time.Duration) time.Duration {
+	prevAt, prevAlloc := a.at, a.totalAlloc
+	a.at, a.totalAlloc = now, fields.TotalAlloc
+
+	if elapsed := now.Sub(prevAt).Seconds(); !prevAt.IsZero() && elapsed > 0 {
+		rate := float64(fields.TotalAlloc-prevAlloc) / elapsed
+
+		switch {
+		case a.avgRate > 0 && rate > adaptiveSpike*a.avgRate:
+			a.cur /= 2
+		case rate <= adaptiveSteady*a.avgRate:
+			a.cur = time.Duration(float64(a.cur) * adaptiveGrow)
+		}
+
+		a.avgRate = adaptiveHistory*a.avgRate + (1-adaptiveHistory)*rate
+	}
+
+	if a.cur < minDur {
+		a.cur = minDur
+	}
+	if a.cur > maxDur {
+		a.cur = maxDur
+	}
+
+	return a.cur
+}
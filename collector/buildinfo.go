@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+// buildInfo holds the build information of the main module, which is read
+// once as it does not change while the process is running.
+type buildInfo struct {
+	version, revision, time string
+}
+
+var (
+	buildInfoOnce sync.Once
+	cachedBuild   buildInfo
+)
+
+func readBuildInfo() buildInfo {
+	buildInfoOnce.Do(func() {
+		info, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+
+		// Binaries built outside of module mode or by go run report "(devel)".
+		if v := info.Main.Version; v != "" && v != "(devel)" {
+			cachedBuild.version = v
+		}
+
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				cachedBuild.revision = s.Value
+			case "vcs.time":
+				cachedBuild.time = s.Value
+			}
+		}
+	})
+
+	return cachedBuild
+}
@@ -1,20 +1,59 @@
 package collector
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 // FieldsFunc represents a callback after successfully gathering statistics
 type FieldsFunc func(Fields)
 
+// processStart approximates the start of the process by the initialization of
+// this package.
+var processStart = time.Now()
+
 // Collector implements the periodic grabbing of informational data from the
-// runtime package and outputting the values to a GaugeFunc.
+// runtime package and outputting the values to a GaugeFunc. Prefer configuring
+// it with NewWithOptions, the exported fields are kept for compatibility and
+// must not be changed once Run has been called.
 type Collector struct {
-	// PauseDur represents the interval in-between each set of stats output.
-	// Defaults to 10 seconds.
+	// PauseDur represents the interval in-between each set of stats output. Use
+	// SetPauseDur to change it once Run has been called. It may only be 0 with a
+	// Trigger. Defaults to 10 seconds.
 	PauseDur time.Duration
 
+	// MinPauseDur and MaxPauseDur enable the adaptive mode when both are set.
+	// Starting at PauseDur, Run then halves the interval (down to MinPauseDur)
+	// when the allocation rate since the previous collection spikes to more than
+	// twice its moving average and lengthens it by half (up to MaxPauseDur) while
+	// the rate stays within 10% of the average. Idle services are therefore
+	// collected rarely and busy ones in finer granularity. EnableMem must also be
+	// set to true for this to take affect. Defaults to 0.
+	MinPauseDur time.Duration
+	MaxPauseDur time.Duration
+
+	// Jitter randomly shortens or lengthens each PauseDur by up to this duration
+	// so that a fleet of processes started together does not collect in lockstep.
+	// It is capped at half of PauseDur. Defaults to 0.
+	Jitter time.Duration
+
+	// GoroutinesMaxInterval enables sampling the number of goroutines in
+	// between collections at this interval, outputting the highest count since
+	// the previous collection as cpu.goroutines.max. It captures bursts of
+	// goroutines that the count at every PauseDur misses. Each sample is a
+	// cheap runtime.NumGoroutine call but wakes the goroutine calling Run, e.g.
+	// ten times a second at 100ms. EnableCPU must also be set to true for this to
+	// take affect. Defaults to 0, disabling the sampling.
+	GoroutinesMaxInterval time.Duration
+
 	// EnableCPU determines whether CPU statistics will be output. Defaults to true.
 	EnableCPU bool
 
@@ -22,49 +61,486 @@ type Collector struct {
 	EnableMem bool
 
 	// EnableGC determines whether garbage collection statistics will be output. EnableMem
-	// must also be set to true for this to take affect. Defaults to true.
+	// must also be set to true for this to take affect, WithMem(false) clears it
+	// as well and RunContext clears it with a warning on the standard logger.
+	// Defaults to true.
 	EnableGC bool
 
+	// EnableRuntimeMetrics determines whether the additional statistics provided by
+	// the runtime/metrics package will be output. The heap live bytes require Go 1.21,
+	// the mutex wait time Go 1.20 and the scheduling latencies Go 1.17. Statistics
+	// unsupported by the running Go version are output as zero. Defaults to false.
+	EnableRuntimeMetrics bool
+
+	// EnableProcess determines whether process statistics (resident set size, CPU
+	// time and open file descriptors) will be output. They are read from /proc on
+	// Linux, getrusage and /dev/fd on macOS and the BSDs (without the resident set
+	// size) and the process APIs on Windows (the open handles count as file
+	// descriptors). Unavailable statistics are output as zero. Defaults to false.
+	EnableProcess bool
+
+	// EnableContention determines whether the totals of the mutex and block profiles
+	// will be output. Reading the profiles copies every profile record on each
+	// collection, which becomes costly with many distinct contention sites. The
+	// profiles must be enabled with runtime.SetMutexProfileFraction and
+	// runtime.SetBlockProfileRate. Defaults to false.
+	EnableContention bool
+
+	// EnableGoroutineStates determines whether the number of goroutines in each
+	// state (cpu.goroutines.running, .runnable, .syscall, .io_wait, .select,
+	// .chan, .sync, .sleep and .waiting for all other states) will be output.
+	// WARNING: the states are parsed from the stack traces of all goroutines,
+	// which stops the world while the stacks are written and allocates memory
+	// proportional to the number of goroutines. Only enable it with a long
+	// PauseDur or while diagnosing deadlocks and leaks. Defaults to false.
+	EnableGoroutineStates bool
+
+	// EnableFinalizers determines whether the finalizer and cleanup queue
+	// statistics (mem.gc.finalizers.* and mem.gc.cleanups.*) will be output. The
+	// .queued and .executed totals count the functions registered with
+	// runtime.SetFinalizer and runtime.AddCleanup and the .pending values are
+	// their difference, i.e. the queue backlog. A growing backlog hints at slow
+	// finalizers holding up the queue and therefore memory. The cleanup totals,
+	// and therefore the pending cleanups, are approximations. Active timers are
+	// not exposed by the runtime and cannot be output. All values require Go 1.25
+	// and are output as zero on older versions. Defaults to false.
+	EnableFinalizers bool
+
+	// EnableSizeClasses determines whether the number of allocations and frees of
+	// every size class of small objects (mem.bysize.<size>.mallocs and .frees,
+	// where size is the largest object size of the class in bytes) will be
+	// output. WARNING: there are 60 size classes, so it adds 120
+	// statistics, each a separate series in most backends, per process. Only
+	// enable it while investigating which object sizes drive the allocations.
+	// EnableMem must also be set to true for this to take affect. Defaults to
+	// false.
+	EnableSizeClasses bool
+
+	// EnableBuildInfo determines whether the build information of the main
+	// module will be output as the tags build.version (the module version),
+	// build.revision and build.time (the VCS revision and commit time). Each tag
+	// is only output when known, e.g. the VCS information requires building with
+	// -buildvcs, which is the default within a repository. The information is
+	// read once, as it does not change. Defaults to false.
+	EnableBuildInfo bool
+
+	// EnableStartEpoch determines whether the time the process started, in
+	// seconds since the Unix epoch, will be output as the tag proc.start_epoch.
+	// The counters, such as mem.total and mem.gc.count, restart at zero with the
+	// process, so a rate computed across a restart is bogus. Grouping by the tag
+	// computes the rates per run of the process instead, e.g. in InfluxQL:
+	//
+	//	SELECT non_negative_derivative(max("mem.gc.count"), 1s) FROM "go.runtime"
+	//	WHERE $timeFilter GROUP BY time($__interval), "proc.start_epoch"
+	//
+	// Every restart starts new series in most backends. Defaults to false.
+	EnableStartEpoch bool
+
+	// EnableDerived determines whether the allocation rate (mem.alloc_rate), GC
+	// rate (mem.gc.rate) and heap object growth rate (mem.heap.objects_rate) will
+	// be output. A growing number of objects while mem.alloc stays flat hints at a
+	// leak of small objects. They are computed per second from the
+	// difference to the previous collection, which is normally PauseDur ago, and
+	// are zero on the first collection. EnableMem must also be set to true for this
+	// to take affect. Defaults to false.
+	EnableDerived bool
+
+	// MemSampleEvery reads the memory statistics only on every Nth collection. The
+	// runtime.ReadMemStats call behind them briefly stops the world, which
+	// latency-sensitive services may not want to pay on every PauseDur. The
+	// collections in between output the CPU and other statistics as usual but
+	// repeat the memory and GC statistics of the last read, so they can be up to N
+	// collections stale. A value of 0 or 1 reads them on every collection.
+	// Defaults to 0.
+	MemSampleEvery int
+
+	// FieldFilter limits the statistics returned by Fields.Values, and therefore
+	// output by every sink, to the listed keys, e.g. "cpu.goroutines" and
+	// "mem.heap.alloc". Use Validate to check the keys. All statistics are output
+	// when empty. Defaults to empty.
+	FieldFilter []string
+
+	// PausePercentiles are the percentiles of the most recent GC pauses (up to 256)
+	// that will be output along with the minimum and maximum pause. Each percentile p
+	// is output as mem.gc.pause_p<p>. Defaults to 50, 95 and 99.
+	PausePercentiles []float64
+
+	// OnPauseExceeded, when set, is called with the duration of every GC pause
+	// longer than PauseThreshold. Each pause is reported once, on the first
+	// collection after the GC finished, including pauses of GCs in between
+	// collections (up to 256). The pauses of GCs before the first collection
+	// are not reported. It is called synchronously while collecting and
+	// must return quickly, e.g. by handing off to a goroutine, or it delays the
+	// collection. EnableMem and EnableGC must also be set to true for this to
+	// take affect.
+	OnPauseExceeded func(pauseNs int64)
+
+	// PauseThreshold is the pause duration above which OnPauseExceeded is
+	// called. Defaults to 0, reporting every pause.
+	PauseThreshold time.Duration
+
+	// OnGOMAXPROCSChange, when set, is called with the previous and the current
+	// value of cpu.gomaxprocs when it changed since the previous collection,
+	// e.g. because code called runtime.GOMAXPROCS or the runtime followed a
+	// changed cgroup CPU limit, to correlate shifts in throughput with it. It is
+	// called synchronously while collecting, like OnPauseExceeded. EnableCPU
+	// must also be set to true for this to take affect.
+	OnGOMAXPROCSChange func(prev, cur int64)
+
+	// HistorySize is the number of the most recent collections of Run retained
+	// in memory and returned by History, e.g. for a local debug endpoint. Every
+	// retained collection holds a copy of Fields. Defaults to 0, retaining none.
+	HistorySize int
+
+	// Trigger, when set, makes Run collect and output statistics on every value
+	// received, e.g. after each batch of requests, in addition to every PauseDur.
+	// Collections caused by Trigger do not delay the next tick. Set PauseDur to 0
+	// to collect only on Trigger, SetPauseDur starts the ticker again. Values
+	// received while paused are discarded, a closed Trigger is ignored.
+	Trigger <-chan struct{}
+
 	// Done, when closed, is used to signal Collector that is should stop collecting
 	// statistics and the Run function should return.
 	Done <-chan struct{}
 
-	fieldsFunc FieldsFunc
+	mu           sync.Mutex
+	sinks        []FieldsFunc
+	tags         map[string]string
+	lastSnapshot *Fields
+	derived      *derivedState
+	memSample    memSample
+	reset        chan struct{}
+	paused       bool
+	goroutineMax int64
+	history      []Fields
+	historyNext  int
+	checkedGC    uint32
+	seededGC     bool
+	gomaxprocs   int64
+	created      time.Time
+
+	// readMemStatsFunc replaces runtime.ReadMemStats when set.
+	readMemStatsFunc func(*runtime.MemStats)
+}
+
+// New creates a new Collector that will periodically output statistics to each of
+// fieldsFuncs. It will also set the values of the exported fields to the described
+// defaults. The values of the exported defaults can be changed at any point before
+// Run is called.
+func New(fieldsFuncs ...FieldsFunc) *Collector {
+	c := &Collector{
+		PauseDur:  10 * time.Second,
+		EnableCPU: true,
+		EnableMem: true,
+		EnableGC:  true,
+
+		PausePercentiles: []float64{50, 95, 99},
+
+		created: time.Now(),
+	}
+
+	for _, fieldsFunc := range fieldsFuncs {
+		c.AddSink(fieldsFunc)
+	}
+
+	return c
+}
+
+// AddSink registers an additional FieldsFunc which receives every set of statistics
+// gathered by Run. Sinks are called sequentially, in the order they were added, on
+// the goroutine calling Run, so a slow sink delays collection. A nil fieldsFunc is
+// ignored. It is safe to call while the Collector is running.
+func (c *Collector) AddSink(fieldsFunc FieldsFunc) {
+	if fieldsFunc == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.sinks = append(c.sinks, fieldsFunc)
+	c.mu.Unlock()
 }
 
-// New creates a new Collector that will periodically output statistics to fieldsFunc. It
-// will also set the values of the exported fields to the described defaults. The values
-// of the exported defaults can be changed at any point before Run is called.
-func New(fieldsFunc FieldsFunc) *Collector {
+// AddFilteredSink registers an additional FieldsFunc like AddSink which only
+// receives the statistics whose keys start with one of prefixes, e.g. "cpu."
+// and "sched." to send a cheap backend only the CPU statistics while other
+// sinks receive all, or "mem.gc." for only the GC statistics. The filter
+// applies to Fields.Values, and therefore to the sinks of this module, on top
+// of FieldFilter; the struct fields and Fields.Tags are left as they are.
+// Unlike the Enable* settings it does not avoid collecting the statistics.
+// Without prefixes it behaves like AddSink.
+func (c *Collector) AddFilteredSink(fieldsFunc FieldsFunc, prefixes ...string) {
+	if len(prefixes) == 0 {
+		c.AddSink(fieldsFunc)
+		return
+	}
 	if fieldsFunc == nil {
-		fieldsFunc = func(Fields) {}
+		return
 	}
 
-	return &Collector{
-		PauseDur:   10 * time.Second,
-		EnableCPU:  true,
-		EnableMem:  true,
-		EnableGC:   true,
-		fieldsFunc: fieldsFunc,
+	prefixes = append([]string{}, prefixes...)
+	c.AddSink(func(fields Fields) {
+		fields.prefixes = prefixes
+		fieldsFunc(fields)
+	})
+}
+
+func (c *Collector) emit(fields Fields) {
+	c.record(fields)
+
+	c.mu.Lock()
+	sinks := c.sinks
+	c.mu.Unlock()
+
+	for _, sink := range sinks {
+		sink(fields)
 	}
 }
 
-// Run gathers statistics then outputs them to the configured PointFunc every
+// Run gathers statistics then outputs them to the configured sinks every
 // PauseDur. Unlike OneOff, this function will return until Done has been closed
 // (or never if Done is nil), therefore it should be called in its own go routine.
+// Use RunContext to stop a Collector without a Done channel. Run panics with the
+// error of Validate when the configuration is invalid, use RunContext to handle
+// it instead.
 func (c *Collector) Run() {
-	c.fieldsFunc(c.collectStats())
+	if err := c.RunContext(context.Background()); err != nil {
+		panic(err)
+	}
+}
 
-	tick := time.NewTicker(c.PauseDur)
+// RunContext behaves like Run but also returns once ctx is done. Whichever of
+// ctx and Done fires first stops collection. The returned error is ctx.Err()
+// when ctx caused the return and nil when Done was closed. It returns the error
+// of Validate without collecting when the configuration is invalid.
+func (c *Collector) RunContext(ctx context.Context) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	if c.EnableGC && !c.EnableMem {
+		log.Println("collector: EnableMem is false, disabling EnableGC as the GC statistics are read with the memory statistics")
+		c.EnableGC = false
+	}
+	trigger := c.Trigger
+
+	fields := c.collectStats()
+	if !c.isPaused() {
+		c.emit(fields)
+	}
+
+	reset := c.resetChan()
+
+	d := c.pauseDur()
+	var adaptive *adaptiveState
+	if c.adaptive() && d > 0 {
+		adaptive = &adaptiveState{cur: d}
+		d = adaptive.next(&fields, time.Now(), c.MinPauseDur, c.MaxPauseDur)
+	}
+
+	// Without a PauseDur only Trigger causes collections, the ticker starts
+	// stopped.
+	tick := time.NewTicker(time.Hour)
 	defer tick.Stop()
+	if d > 0 {
+		tick.Reset(Jittered(d, c.Jitter))
+	} else {
+		tick.Stop()
+	}
+
+	var sample <-chan time.Time
+	if c.GoroutinesMaxInterval > 0 && c.EnableCPU {
+		sampleTick := time.NewTicker(c.GoroutinesMaxInterval)
+		defer sampleTick.Stop()
+		sample = sampleTick.C
+	}
+
 	for {
 		select {
+		case <-sample:
+			c.sampleGoroutines()
+		case <-ctx.Done():
+			return ctx.Err()
 		case <-c.Done:
-			return
+			return nil
+		case <-reset:
+			d := c.pauseDur()
+			if c.isPaused() || d <= 0 {
+				tick.Stop()
+				break
+			}
+
+			if adaptive != nil {
+				adaptive.cur = d
+			}
+			tick.Reset(Jittered(d, c.Jitter))
 		case <-tick.C:
-			c.fieldsFunc(c.collectStats())
+			// A tick may have been pending when Pause was called.
+			if c.isPaused() {
+				break
+			}
+
+			fields := c.collectStats()
+			if adaptive != nil {
+				tick.Reset(Jittered(adaptive.next(&fields, time.Now(), c.MinPauseDur, c.MaxPauseDur), c.Jitter))
+			} else if c.Jitter > 0 {
+				tick.Reset(Jittered(c.pauseDur(), c.Jitter))
+			}
+			c.emit(fields)
+		case _, ok := <-trigger:
+			if !ok {
+				trigger = nil
+				break
+			}
+			if c.isPaused() {
+				break
+			}
+
+			c.emit(c.collectStats())
+		}
+	}
+}
+
+// SetPauseDur changes PauseDur. When Run is active the next collection happens
+// d after the call, e.g. to collect more frequently during an incident.
+// Non-positive durations are ignored. It is safe to call while the Collector is
+// running.
+func (c *Collector) SetPauseDur(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	c.PauseDur = d
+	c.mu.Unlock()
+
+	// Wake up Run, the pending signal is enough if there is one already.
+	select {
+	case c.resetChan() <- struct{}{}:
+	default:
+	}
+}
+
+// Pause stops Run from collecting until Resume is called, without stopping its
+// goroutine, e.g. during a maintenance window. A collection in progress still
+// completes and OneOff keeps working while paused. It is safe to call while the
+// Collector is running.
+func (c *Collector) Pause() {
+	c.setPaused(true)
+}
+
+// Resume restarts collecting after Pause. The next collection happens PauseDur
+// after the call.
+func (c *Collector) Resume() {
+	c.setPaused(false)
+}
+
+func (c *Collector) setPaused(paused bool) {
+	c.mu.Lock()
+	changed := c.paused != paused
+	c.paused = paused
+	c.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	select {
+	case c.resetChan() <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Collector) isPaused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// sampleGoroutines raises the high-water mark of the goroutine count.
+func (c *Collector) sampleGoroutines() {
+	n := int64(runtime.NumGoroutine())
+
+	c.mu.Lock()
+	if n > c.goroutineMax {
+		c.goroutineMax = n
+	}
+	c.mu.Unlock()
+}
+
+// resetGoroutineMax returns the high-water mark of the goroutine count since
+// the previous call, which is at least cur, and starts a new one.
+func (c *Collector) resetGoroutineMax(cur int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	highest := c.goroutineMax
+	c.goroutineMax = 0
+	if cur > highest {
+		highest = cur
+	}
+	return highest
+}
+
+func (c *Collector) pauseDur() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.PauseDur
+}
+
+func (c *Collector) resetChan() chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.reset == nil {
+		c.reset = make(chan struct{}, 1)
+	}
+	return c.reset
+}
+
+// Jittered returns d randomly adjusted by up to plus or minus jitter. The jitter
+// is capped at half of d so the result is always positive.
+func Jittered(d, jitter time.Duration) time.Duration {
+	if jitter <= 0 || d <= 0 {
+		return d
+	}
+
+	if jitter > d/2 {
+		jitter = d / 2
+	}
+
+	return d - jitter + time.Duration(rand.Int63n(int64(2*jitter)+1))
+}
+
+// SetTags sets additional tags that are returned by Fields.Tags for every
+// subsequent collection. Tags conflicting with the built-in tags take precedence.
+// It is safe to call while the Collector is running.
+func (c *Collector) SetTags(tags map[string]string) {
+	copied := make(map[string]string, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+
+	c.mu.Lock()
+	c.tags = copied
+	c.mu.Unlock()
+}
+
+// Validate returns an error if FieldFilter contains a key that is not a known
+// statistic or if PauseDur is not positive and there is no Trigger.
+func (c *Collector) Validate() error {
+	if d := c.pauseDur(); d <= 0 && c.Trigger == nil {
+		return fmt.Errorf("PauseDur must be positive without a Trigger, got %v", d)
+	}
+
+	known := (&Fields{PausePercentiles: c.pausePercentileKeys()}).Values()
+
+	for _, key := range c.FieldFilter {
+		if _, ok := known[key]; !ok && !isSizeClassKey(key) {
+			return fmt.Errorf("unknown field %q in FieldFilter", key)
 		}
 	}
+
+	return nil
 }
 
 // OneOff gathers returns a map containing all statistics. It is safe for use from
@@ -73,37 +549,133 @@ func (c *Collector) OneOff() Fields {
 	return c.collectStats()
 }
 
+// OneOffAsync behaves like OneOff but collects on a new goroutine, so the
+// caller is not blocked by reading the memory statistics, and returns a channel
+// delivering the result. The channel is buffered, the goroutine exits even if
+// the result is never received.
+func (c *Collector) OneOffAsync() <-chan Fields {
+	result := make(chan Fields, 1)
+	go func() {
+		result <- c.collectStats()
+	}()
+	return result
+}
+
 func (c *Collector) collectStats() Fields {
 	fields := Fields{}
 
 	if c.EnableCPU {
-		cStats := cpuStats{
-			NumGoroutine: int64(runtime.NumGoroutine()),
-			NumCgoCall:   int64(runtime.NumCgoCall()),
-			NumCpu:       int64(runtime.NumCPU()),
-		}
+		cStats := readCPUStats()
 		c.collectCPUStats(&fields, &cStats)
+		if c.GoroutinesMaxInterval > 0 {
+			fields.NumGoroutineMax = c.resetGoroutineMax(cStats.NumGoroutine)
+		}
+		if c.OnGOMAXPROCSChange != nil {
+			c.checkGOMAXPROCS(cStats.GOMAXPROCS)
+		}
 	}
+	var memReadAt time.Time
 	if c.EnableMem {
-		m := &runtime.MemStats{}
-		runtime.ReadMemStats(m)
+		var m *runtime.MemStats
+		m, memReadAt = c.readMemStats()
 		c.collectMemStats(&fields, m)
+		if c.EnableSizeClasses {
+			c.collectSizeClasses(&fields, m)
+		}
 		if c.EnableGC {
 			c.collectGCStats(&fields, m)
 		}
 	}
+	if c.EnableRuntimeMetrics {
+		c.collectRuntimeMetrics(&fields)
+	}
+	if c.EnableFinalizers {
+		c.collectFinalizerStats(&fields)
+	}
+	if c.EnableContention {
+		sStats := contentionStats{}
+		readContentionStats(&sStats)
+		c.collectContentionStats(&fields, &sStats)
+	}
+	if c.EnableGoroutineStates {
+		gStats := goroutineStates{}
+		readGoroutineStates(&gStats)
+		c.collectGoroutineStates(&fields, &gStats)
+	}
+	if c.EnableProcess {
+		pStats := procStats{}
+		readProcStats(&pStats)
+		c.collectProcStats(&fields, &pStats)
+	}
+	if c.EnableDerived && c.EnableMem {
+		c.collectDerivedStats(&fields, memReadAt)
+	}
+
+	fields.Up = 1
+	if !c.created.IsZero() {
+		fields.Uptime = time.Since(c.created).Seconds()
+	}
 
 	fields.Goos = runtime.GOOS
 	fields.Goarch = runtime.GOARCH
 	fields.Version = runtime.Version()
 
+	if c.EnableBuildInfo {
+		build := readBuildInfo()
+		fields.BuildVersion = build.version
+		fields.BuildRevision = build.revision
+		fields.BuildTime = build.time
+	}
+	if c.EnableStartEpoch {
+		fields.StartEpoch = processStart.Unix()
+	}
+
+	c.mu.Lock()
+	fields.ExtraTags = c.tags
+	c.mu.Unlock()
+
+	if len(c.FieldFilter) > 0 {
+		fields.filter = make(map[string]struct{}, len(c.FieldFilter))
+		for _, key := range c.FieldFilter {
+			fields.filter[key] = struct{}{}
+		}
+	}
+
 	return fields
 }
 
-func (_ *Collector) collectCPUStats(fields *Fields, s *cpuStats) {
+func (_ *Collector) collectCPUStats(fields *Fields, s *CPUStats) {
 	fields.NumCpu = s.NumCpu
 	fields.NumGoroutine = s.NumGoroutine
 	fields.NumCgoCall = s.NumCgoCall
+	fields.GOMAXPROCS = s.GOMAXPROCS
+	fields.NumOSThread = s.NumOSThread
+	fields.NumThread = s.NumThread
+	fields.NumGoroutineWaiting = s.NumGoroutineWaiting
+}
+
+func (_ *Collector) collectProcStats(fields *Fields, s *procStats) {
+	fields.ProcRSS = s.RSS
+	fields.ProcCPUUser = s.CPUUser
+	fields.ProcCPUSystem = s.CPUSystem
+	fields.ProcOpenFDs = s.OpenFDs
+}
+
+func (_ *Collector) collectContentionStats(fields *Fields, s *contentionStats) {
+	fields.MutexWaitTotal = s.MutexWaitCycles
+	fields.BlockTotal = s.BlockCount
+}
+
+func (_ *Collector) collectGoroutineStates(fields *Fields, s *goroutineStates) {
+	fields.GoroutinesRunning = s.Running
+	fields.GoroutinesRunnable = s.Runnable
+	fields.GoroutinesSyscall = s.Syscall
+	fields.GoroutinesIOWait = s.IOWait
+	fields.GoroutinesSelect = s.Select
+	fields.GoroutinesChan = s.Chan
+	fields.GoroutinesSync = s.Sync
+	fields.GoroutinesSleep = s.Sleep
+	fields.GoroutinesWaiting = s.Waiting
 }
 
 func (_ *Collector) collectMemStats(fields *Fields, m *runtime.MemStats) {
@@ -122,6 +694,10 @@ func (_ *Collector) collectMemStats(fields *Fields, m *runtime.MemStats) {
 	fields.HeapInuse = int64(m.HeapInuse)
 	fields.HeapReleased = int64(m.HeapReleased)
 	fields.HeapObjects = int64(m.HeapObjects)
+	fields.HeapUnreleased = int64(m.HeapIdle - m.HeapReleased)
+	if m.HeapSys > 0 {
+		fields.HeapFragmentation = float64(m.HeapInuse) / float64(m.HeapSys)
+	}
 
 	// Stack
 	fields.StackInuse = int64(m.StackInuse)
@@ -130,24 +706,64 @@ func (_ *Collector) collectMemStats(fields *Fields, m *runtime.MemStats) {
 	fields.MSpanSys = int64(m.MSpanSys)
 	fields.MCacheInuse = int64(m.MCacheInuse)
 	fields.MCacheSys = int64(m.MCacheSys)
+	if m.HeapInuse > 0 {
+		fields.StackHeapRatio = float64(m.StackInuse) / float64(m.HeapInuse)
+	}
 
 	fields.OtherSys = int64(m.OtherSys)
 }
 
-func (_ *Collector) collectGCStats(fields *Fields, m *runtime.MemStats) {
+func (c *Collector) collectGCStats(fields *Fields, m *runtime.MemStats) {
 	fields.GCSys = int64(m.GCSys)
 	fields.NextGC = int64(m.NextGC)
 	fields.LastGC = int64(m.LastGC)
 	fields.PauseTotalNs = int64(m.PauseTotalNs)
 	fields.PauseNs = int64(m.PauseNs[(m.NumGC+255)%256])
+	c.collectPauseStats(fields, m)
+	if c.OnPauseExceeded != nil {
+		c.checkPauses(m)
+	}
 	fields.NumGC = int64(m.NumGC)
 	fields.GCCPUFraction = float64(m.GCCPUFraction)
+	fields.NumForcedGC = int64(m.NumForcedGC)
+	if m.NextGC > 0 {
+		fields.HeapGoalRatio = float64(m.HeapAlloc) / float64(m.NextGC)
+	}
+	if m.LastGC > 0 {
+		fields.LastGCAge = time.Now().UnixNano() - int64(m.LastGC)
+	}
 }
 
-type cpuStats struct {
+// CPUStats holds the CPU and scheduler statistics, which are cheap to gather
+// compared to the memory statistics. See OneOffCPU.
+type CPUStats struct {
 	NumCpu       int64
 	NumGoroutine int64
 	NumCgoCall   int64
+	GOMAXPROCS   int64
+	NumOSThread  int64
+
+	NumThread           int64
+	NumGoroutineWaiting int64
+}
+
+// OneOffCPU gathers only the CPU statistics, which are output as cpu.* and
+// sched.* by OneOff. Unlike OneOff it neither reads the memory statistics nor
+// builds Fields, which makes it suitable for sub-second monitoring of, e.g., the
+// goroutine count. It ignores the Enable* settings and is safe for use from
+// multiple go routines.
+func (c *Collector) OneOffCPU() CPUStats {
+	return readCPUStats()
+}
+
+func readCPUStats() CPUStats {
+	s := CPUStats{
+		NumGoroutine: int64(runtime.NumGoroutine()),
+		NumCgoCall:   int64(runtime.NumCgoCall()),
+		NumCpu:       int64(runtime.NumCPU()),
+	}
+	readSchedStats(&s)
+	return s
 }
 
 // NOTE: uint64 is not supported by influxDB client due to potential overflows
@@ -156,6 +772,20 @@ type Fields struct {
 	NumCpu       int64 `json:"cpu.count"`
 	NumGoroutine int64 `json:"cpu.goroutines"`
 	NumCgoCall   int64 `json:"cpu.cgo_calls"`
+	GOMAXPROCS   int64 `json:"cpu.gomaxprocs"`
+
+	// NumOSThread is the number of OS threads created by the runtime since the
+	// start, including exited ones. Unlike sched.threads, the live threads, it
+	// shows threads spawned for blocking cgo and system calls.
+	NumOSThread int64 `json:"cpu.os_threads"`
+
+	// NumGoroutineMax is the highest number of goroutines sampled since the
+	// previous collection, see Collector.GoroutinesMaxInterval.
+	NumGoroutineMax int64 `json:"cpu.goroutines.max"`
+
+	// Scheduler
+	NumThread           int64 `json:"sched.threads"`
+	NumGoroutineWaiting int64 `json:"sched.goroutines_waiting"`
 
 	// General
 	Alloc      int64 `json:"mem.alloc"`
@@ -173,6 +803,12 @@ type Fields struct {
 	HeapReleased int64 `json:"mem.heap.released"`
 	HeapObjects  int64 `json:"mem.heap.objects"`
 
+	// HeapUnreleased is the idle heap memory not yet returned to the OS
+	// (HeapIdle - HeapReleased) and HeapFragmentation the share of the heap
+	// obtained from the OS that is in use (HeapInuse / HeapSys).
+	HeapUnreleased    int64   `json:"mem.heap.unreleased"`
+	HeapFragmentation float64 `json:"mem.heap.fragmentation"`
+
 	// Stack
 	StackInuse  int64 `json:"mem.stack.inuse"`
 	StackSys    int64 `json:"mem.stack.sys"`
@@ -181,8 +817,18 @@ type Fields struct {
 	MCacheInuse int64 `json:"mem.stack.mcache_inuse"`
 	MCacheSys   int64 `json:"mem.stack.mcache_sys"`
 
+	// StackHeapRatio is the stack memory in use relative to the heap memory in
+	// use (StackInuse / HeapInuse). A rising ratio points at a growing number of
+	// goroutines rather than a growing heap.
+	StackHeapRatio float64 `json:"mem.stack_heap_ratio"`
+
 	OtherSys int64 `json:"mem.othersys"`
 
+	// Derived
+	AllocRate       float64 `json:"mem.alloc_rate"`
+	GCRate          float64 `json:"mem.gc.rate"`
+	HeapObjectsRate float64 `json:"mem.heap.objects_rate"`
+
 	// GC
 	GCSys         int64   `json:"mem.gc.sys"`
 	NextGC        int64   `json:"mem.gc.next"`
@@ -191,54 +837,307 @@ type Fields struct {
 	PauseNs       int64   `json:"mem.gc.pause"`
 	NumGC         int64   `json:"mem.gc.count"`
 	GCCPUFraction float64 `json:"mem.gc.cpu_fraction"`
+	NumForcedGC   int64   `json:"mem.gc.num_forced"`
+	LastGCAge     int64   `json:"mem.gc.age_ns"`
+	PauseMin      int64   `json:"mem.gc.pause_min"`
+	PauseMax      int64   `json:"mem.gc.pause_max"`
+
+	// HeapGoalRatio is HeapAlloc / NextGC, approaching 1 as the next GC becomes
+	// imminent.
+	HeapGoalRatio float64 `json:"mem.gc.heap_goal_ratio"`
+
+	// PausePercentiles maps mem.gc.pause_p<p> keys to the pause percentiles
+	// configured by Collector.PausePercentiles.
+	PausePercentiles map[string]int64 `json:"-"`
+
+	// SizeClasses maps mem.bysize.<size>.mallocs and .frees keys to the counts
+	// of the size classes, see Collector.EnableSizeClasses.
+	SizeClasses map[string]int64 `json:"-"`
+
+	// Up is always 1, so the absence of proc.up reveals a process that stopped
+	// reporting.
+	Up int64 `json:"proc.up"`
+
+	// Uptime is the number of seconds since the Collector was created by New,
+	// which is normally at the start of the process. It is zero for a Collector
+	// that was not created by New.
+	Uptime float64 `json:"proc.uptime_seconds"`
+
+	// Process
+	ProcRSS       int64 `json:"proc.rss"`
+	ProcCPUUser   int64 `json:"proc.cpu_user"`
+	ProcCPUSystem int64 `json:"proc.cpu_system"`
+	ProcOpenFDs   int64 `json:"proc.open_fds"`
+
+	// Contention
+	MutexWaitTotal int64 `json:"sync.mutex_wait_total"`
+	BlockTotal     int64 `json:"sync.block_total"`
+
+	// Goroutine states
+	GoroutinesRunning  int64 `json:"cpu.goroutines.running"`
+	GoroutinesRunnable int64 `json:"cpu.goroutines.runnable"`
+	GoroutinesSyscall  int64 `json:"cpu.goroutines.syscall"`
+	GoroutinesIOWait   int64 `json:"cpu.goroutines.io_wait"`
+	GoroutinesSelect   int64 `json:"cpu.goroutines.select"`
+	GoroutinesChan     int64 `json:"cpu.goroutines.chan"`
+	GoroutinesSync     int64 `json:"cpu.goroutines.sync"`
+	GoroutinesSleep    int64 `json:"cpu.goroutines.sleep"`
+	GoroutinesWaiting  int64 `json:"cpu.goroutines.waiting"`
+
+	// Runtime metrics
+	HeapLive        int64 `json:"mem.gc.heap_live"`
+	SchedLatencyP50 int64 `json:"sched.latency_p50"`
+	SchedLatencyP99 int64 `json:"sched.latency_p99"`
+	MutexWaitNs     int64 `json:"sync.mutex_wait_ns"`
+
+	// Finalizers
+	FinalizersQueued   int64 `json:"mem.gc.finalizers.queued"`
+	FinalizersExecuted int64 `json:"mem.gc.finalizers.executed"`
+	FinalizersPending  int64 `json:"mem.gc.finalizers.pending"`
+	CleanupsQueued     int64 `json:"mem.gc.cleanups.queued"`
+	CleanupsExecuted   int64 `json:"mem.gc.cleanups.executed"`
+	CleanupsPending    int64 `json:"mem.gc.cleanups.pending"`
 
 	Goarch  string `json:"-"`
 	Goos    string `json:"-"`
 	Version string `json:"-"`
+
+	// Build information, see Collector.EnableBuildInfo.
+	BuildVersion  string `json:"-"`
+	BuildRevision string `json:"-"`
+	BuildTime     string `json:"-"`
+
+	// StartEpoch is the time the process started in seconds since the Unix
+	// epoch, see Collector.EnableStartEpoch. It is output as a tag unless zero.
+	StartEpoch int64 `json:"-"`
+
+	// ExtraTags are the tags set with Collector.SetTags.
+	ExtraTags map[string]string `json:"-"`
+
+	// Extra holds additional statistics output by Values, e.g. added by a
+	// FieldsInterceptor of runstats. Values must be of one of the types
+	// described by Values. Keys of the built-in statistics take precedence.
+	Extra map[string]interface{} `json:"-"`
+
+	// filter holds the keys of Collector.FieldFilter.
+	filter map[string]struct{}
+
+	// prefixes holds the prefixes of a sink added with AddFilteredSink.
+	prefixes []string
+}
+
+// MarshalJSON encodes the Fields as a flat object of the keys and values
+// returned by Values.
+func (f Fields) MarshalJSON() ([]byte, error) {
+	values := valuesPool.Get().(map[string]interface{})
+	defer putValues(values)
+
+	return json.Marshal(f.ValuesInto(values))
+}
+
+// numValues is the capacity hint for maps holding the keys returned by Values.
+const numValues = 80
+
+// Maps reused by MarshalJSON, which is called on every expvar request
+var valuesPool = sync.Pool{
+	New: func() interface{} {
+		return make(map[string]interface{}, numValues)
+	},
+}
+
+func putValues(values map[string]interface{}) {
+	for key := range values {
+		delete(values, key)
+	}
+	valuesPool.Put(values)
 }
 
 func (f *Fields) Tags() map[string]string {
-	return map[string]string{
+	tags := map[string]string{
 		"go.os":      f.Goos,
 		"go.arch":    f.Goarch,
 		"go.version": f.Version,
 	}
+
+	if f.BuildVersion != "" {
+		tags["build.version"] = f.BuildVersion
+	}
+	if f.BuildRevision != "" {
+		tags["build.revision"] = f.BuildRevision
+	}
+	if f.BuildTime != "" {
+		tags["build.time"] = f.BuildTime
+	}
+	if f.StartEpoch != 0 {
+		tags["proc.start_epoch"] = strconv.FormatInt(f.StartEpoch, 10)
+	}
+
+	for k, v := range f.ExtraTags {
+		tags[k] = v
+	}
+
+	return tags
 }
 
+// Values returns the statistics keyed by their name, e.g. "mem.heap.alloc". A
+// new map is allocated on every call, use ValuesInto to reuse one. Values keep
+// their native type, which is int64, float64, bool or string. The built-in
+// statistics are int64 or float64, sinks skip the types they cannot represent.
 func (f *Fields) Values() map[string]interface{} {
-	return map[string]interface{}{
-		"cpu.count":      f.NumCpu,
-		"cpu.goroutines": f.NumGoroutine,
-		"cpu.cgo_calls":  f.NumCgoCall,
-
-		"mem.alloc":   f.Alloc,
-		"mem.total":   f.TotalAlloc,
-		"mem.sys":     f.Sys,
-		"mem.lookups": f.Lookups,
-		"mem.malloc":  f.Mallocs,
-		"mem.frees":   f.Frees,
-
-		"mem.heap.alloc":    f.HeapAlloc,
-		"mem.heap.sys":      f.HeapSys,
-		"mem.heap.idle":     f.HeapIdle,
-		"mem.heap.inuse":    f.HeapInuse,
-		"mem.heap.released": f.HeapReleased,
-		"mem.heap.objects":  f.HeapObjects,
-
-		"mem.stack.inuse":        f.StackInuse,
-		"mem.stack.sys":          f.StackSys,
-		"mem.stack.mspan_inuse":  f.MSpanInuse,
-		"mem.stack.mspan_sys":    f.MSpanSys,
-		"mem.stack.mcache_inuse": f.MCacheInuse,
-		"mem.stack.mcache_sys":   f.MCacheSys,
-		"mem.othersys":           f.OtherSys,
-
-		"mem.gc.sys":          f.GCSys,
-		"mem.gc.next":         f.NextGC,
-		"mem.gc.last":         f.LastGC,
-		"mem.gc.pause_total":  f.PauseTotalNs,
-		"mem.gc.pause":        f.PauseNs,
-		"mem.gc.count":        f.NumGC,
-		"mem.gc.cpu_fraction": float64(f.GCCPUFraction),
+	size := numValues + len(f.PausePercentiles) + len(f.SizeClasses) + len(f.Extra)
+	if f.filter != nil {
+		size = len(f.filter)
+	}
+
+	values := make(map[string]interface{}, size)
+	f.ValuesInto(values)
+	return values
+}
+
+// ValuesInto stores the statistics returned by Values in m and returns it,
+// overwriting existing keys. Unlike Values it does not allocate a new map, so
+// callers collecting frequently can clear and reuse the same map.
+func (f *Fields) ValuesInto(m map[string]interface{}) map[string]interface{} {
+	if f.filter == nil && f.prefixes == nil {
+		return f.allValuesInto(m)
+	}
+
+	all := valuesPool.Get().(map[string]interface{})
+	defer putValues(all)
+
+	f.allValuesInto(all)
+	if f.filter == nil {
+		for key, value := range all {
+			if f.hasPrefix(key) {
+				m[key] = value
+			}
+		}
+		return m
+	}
+
+	for key := range f.filter {
+		if value, ok := all[key]; ok && f.hasPrefix(key) {
+			m[key] = value
+		}
 	}
+
+	return m
+}
+
+// hasPrefix reports whether key starts with one of the prefixes of the sink,
+// which is true for every key without prefixes.
+func (f *Fields) hasPrefix(key string) bool {
+	if f.prefixes == nil {
+		return true
+	}
+
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// allValuesInto stores all statistics in m regardless of the filter.
+func (f *Fields) allValuesInto(m map[string]interface{}) map[string]interface{} {
+	m["cpu.count"] = f.NumCpu
+	m["cpu.goroutines"] = f.NumGoroutine
+	m["cpu.cgo_calls"] = f.NumCgoCall
+	m["cpu.gomaxprocs"] = f.GOMAXPROCS
+	m["cpu.os_threads"] = f.NumOSThread
+	m["cpu.goroutines.max"] = f.NumGoroutineMax
+
+	m["sched.threads"] = f.NumThread
+	m["sched.goroutines_waiting"] = f.NumGoroutineWaiting
+
+	m["mem.alloc"] = f.Alloc
+	m["mem.total"] = f.TotalAlloc
+	m["mem.sys"] = f.Sys
+	m["mem.lookups"] = f.Lookups
+	m["mem.malloc"] = f.Mallocs
+	m["mem.frees"] = f.Frees
+
+	m["mem.heap.alloc"] = f.HeapAlloc
+	m["mem.heap.sys"] = f.HeapSys
+	m["mem.heap.idle"] = f.HeapIdle
+	m["mem.heap.inuse"] = f.HeapInuse
+	m["mem.heap.released"] = f.HeapReleased
+	m["mem.heap.objects"] = f.HeapObjects
+	m["mem.heap.unreleased"] = f.HeapUnreleased
+	m["mem.heap.fragmentation"] = f.HeapFragmentation
+
+	m["mem.stack.inuse"] = f.StackInuse
+	m["mem.stack.sys"] = f.StackSys
+	m["mem.stack.mspan_inuse"] = f.MSpanInuse
+	m["mem.stack.mspan_sys"] = f.MSpanSys
+	m["mem.stack.mcache_inuse"] = f.MCacheInuse
+	m["mem.stack.mcache_sys"] = f.MCacheSys
+	m["mem.stack_heap_ratio"] = f.StackHeapRatio
+	m["mem.othersys"] = f.OtherSys
+
+	m["mem.alloc_rate"] = f.AllocRate
+	m["mem.gc.rate"] = f.GCRate
+	m["mem.heap.objects_rate"] = f.HeapObjectsRate
+
+	m["mem.gc.sys"] = f.GCSys
+	m["mem.gc.next"] = f.NextGC
+	m["mem.gc.last"] = f.LastGC
+	m["mem.gc.pause_total"] = f.PauseTotalNs
+	m["mem.gc.pause"] = f.PauseNs
+	m["mem.gc.count"] = f.NumGC
+	m["mem.gc.cpu_fraction"] = f.GCCPUFraction
+	m["mem.gc.num_forced"] = f.NumForcedGC
+	m["mem.gc.age_ns"] = f.LastGCAge
+	m["mem.gc.pause_min"] = f.PauseMin
+	m["mem.gc.pause_max"] = f.PauseMax
+	m["mem.gc.heap_goal_ratio"] = f.HeapGoalRatio
+
+	m["proc.up"] = f.Up
+	m["proc.uptime_seconds"] = f.Uptime
+	m["proc.rss"] = f.ProcRSS
+	m["proc.cpu_user"] = f.ProcCPUUser
+	m["proc.cpu_system"] = f.ProcCPUSystem
+	m["proc.open_fds"] = f.ProcOpenFDs
+
+	m["sync.mutex_wait_total"] = f.MutexWaitTotal
+	m["sync.block_total"] = f.BlockTotal
+
+	m["cpu.goroutines.running"] = f.GoroutinesRunning
+	m["cpu.goroutines.runnable"] = f.GoroutinesRunnable
+	m["cpu.goroutines.syscall"] = f.GoroutinesSyscall
+	m["cpu.goroutines.io_wait"] = f.GoroutinesIOWait
+	m["cpu.goroutines.select"] = f.GoroutinesSelect
+	m["cpu.goroutines.chan"] = f.GoroutinesChan
+	m["cpu.goroutines.sync"] = f.GoroutinesSync
+	m["cpu.goroutines.sleep"] = f.GoroutinesSleep
+	m["cpu.goroutines.waiting"] = f.GoroutinesWaiting
+
+	m["mem.gc.heap_live"] = f.HeapLive
+	m["sched.latency_p50"] = f.SchedLatencyP50
+	m["sched.latency_p99"] = f.SchedLatencyP99
+	m["sync.mutex_wait_ns"] = f.MutexWaitNs
+
+	m["mem.gc.finalizers.queued"] = f.FinalizersQueued
+	m["mem.gc.finalizers.executed"] = f.FinalizersExecuted
+	m["mem.gc.finalizers.pending"] = f.FinalizersPending
+	m["mem.gc.cleanups.queued"] = f.CleanupsQueued
+	m["mem.gc.cleanups.executed"] = f.CleanupsExecuted
+	m["mem.gc.cleanups.pending"] = f.CleanupsPending
+
+	for key, value := range f.PausePercentiles {
+		m[key] = value
+	}
+
+	for key, value := range f.SizeClasses {
+		m[key] = value
+	}
+
+	for key, value := range f.Extra {
+		if _, builtin := LookupFieldMeta(key); !builtin {
+			m[key] = value
+		}
+	}
+
+	return m
 }
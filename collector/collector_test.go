@@ -1,6 +1,19 @@
 package collector
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -31,6 +44,7 @@ func TestCollector(t *testing.T) {
 
 	expKeys := []string{
 		"cpu.goroutines",
+		"sched.threads",
 		"mem.lookups",
 		"mem.gc.count",
 	}
@@ -43,9 +57,1285 @@ func TestCollector(t *testing.T) {
 		}
 	}
 
+	for _, fields := range latestFields {
+		if fields.NumThread <= 0 {
+			t.Errorf("expected a positive thread count, got %d", fields.NumThread)
+		}
+	}
+
 	expected := 10
 	if points := len(latestFields); points < expected {
 		t.Errorf("num of points is lower than expected:\ngot: %d\nexp: %d", points, expected)
 	}
 
 }
+
+func TestCollectorRunContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := New(nil)
+	c.PauseDur = 10 * time.Millisecond
+
+	errc := make(chan error)
+	go func() {
+		errc <- c.RunContext(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("expected error (%v) got (%v)", context.Canceled, err)
+	}
+
+	done := make(chan struct{})
+	c.Done = done
+	go func() {
+		errc <- c.RunContext(context.Background())
+	}()
+	close(done)
+
+	if err := <-errc; err != nil {
+		t.Errorf("expected nil error when Done is closed, got (%v)", err)
+	}
+}
+
+func TestCollectorGoroutinesMax(t *testing.T) {
+	var mu sync.Mutex
+	var collected []Fields
+
+	c := New(func(fields Fields) {
+		mu.Lock()
+		collected = append(collected, fields)
+		mu.Unlock()
+	})
+	c.PauseDur = 200 * time.Millisecond
+	c.GoroutinesMaxInterval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		c.RunContext(ctx)
+	}()
+
+	// A burst of goroutines exiting before the next collection
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-release
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	time.Sleep(250 * time.Millisecond)
+	cancel()
+	<-stopped
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(collected) < 2 {
+		t.Fatalf("expected at least 2 collections, got %d", len(collected))
+	}
+
+	if last := collected[1]; last.NumGoroutineMax < last.NumGoroutine+100 {
+		t.Errorf("expected the burst to be captured, got cpu.goroutines.max (%d) with cpu.goroutines (%d)", last.NumGoroutineMax, last.NumGoroutine)
+	}
+}
+
+func TestCollectorUp(t *testing.T) {
+	c := New(nil)
+	c.EnableCPU = false
+	c.EnableMem = false
+
+	fields := c.OneOff()
+	if up := fields.Values()["proc.up"]; up != int64(1) {
+		t.Errorf("expected proc.up (1), got (%v)", up)
+	}
+}
+
+func TestCollectorUptime(t *testing.T) {
+	c := New(nil)
+	time.Sleep(10 * time.Millisecond)
+
+	first := c.OneOff().Uptime
+	if first < 0.01 {
+		t.Errorf("expected proc.uptime_seconds of at least 0.01, got %v", first)
+	}
+	if second := c.OneOff().Uptime; second < first {
+		t.Errorf("expected proc.uptime_seconds to grow, got %v after %v", second, first)
+	}
+
+	if uptime := (&Collector{}).OneOff().Uptime; uptime != 0 {
+		t.Errorf("expected zero proc.uptime_seconds without New, got %v", uptime)
+	}
+}
+
+func TestCollectorRunContextNoLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 10; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		c := New(func(Fields) {})
+		c.PauseDur = time.Millisecond
+
+		errc := make(chan error, 1)
+		go func() { errc <- c.RunContext(ctx) }()
+
+		time.Sleep(5 * time.Millisecond)
+		cancel()
+		<-errc
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("expected no leaked goroutines, got %d before and %d after", before, after)
+	}
+}
+
+func TestCollectorRuntimeMetrics(t *testing.T) {
+	c := New(nil)
+	c.EnableRuntimeMetrics = true
+
+	// Live heap bytes are only known after the first GC cycle.
+	runtime.GC()
+
+	fields := c.OneOff()
+	if fields.HeapLive <= 0 {
+		t.Errorf("expected positive mem.gc.heap_live, got %d", fields.HeapLive)
+	}
+	if fields.SchedLatencyP99 < fields.SchedLatencyP50 {
+		t.Errorf("expected p99 (%d) >= p50 (%d)", fields.SchedLatencyP99, fields.SchedLatencyP50)
+	}
+}
+
+func TestCollectorFinalizers(t *testing.T) {
+	c := New(nil)
+	c.EnableFinalizers = true
+
+	before := c.OneOff()
+
+	executed := make(chan struct{}, 8)
+	for i := 0; i < cap(executed); i++ {
+		runtime.SetFinalizer(new([16]byte), func(*[16]byte) { executed <- struct{}{} })
+	}
+	runtime.GC()
+	for i := 0; i < cap(executed); i++ {
+		select {
+		case <-executed:
+		case <-time.After(time.Second):
+			t.Fatal("finalizers not executed")
+		}
+	}
+
+	after := c.OneOff()
+	if after.FinalizersQueued < before.FinalizersQueued+int64(cap(executed)) {
+		t.Errorf("expected at least %d finalizers queued, got %d", cap(executed), after.FinalizersQueued-before.FinalizersQueued)
+	}
+	if after.FinalizersPending != after.FinalizersQueued-after.FinalizersExecuted {
+		t.Errorf("expected pending (%d) to be queued (%d) - executed (%d)", after.FinalizersPending, after.FinalizersQueued, after.FinalizersExecuted)
+	}
+}
+
+func TestCollectorSizeClasses(t *testing.T) {
+	c := New(nil)
+	c.EnableSizeClasses = true
+	c.FieldFilter = []string{"mem.bysize.16.mallocs"}
+
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	allocSink = make([]byte, 16)
+
+	fields := c.OneOff()
+	if len(fields.SizeClasses) < 2 || fields.SizeClasses["mem.bysize.16.mallocs"] <= 0 {
+		t.Errorf("unexpected size classes %v", fields.SizeClasses)
+	}
+
+	if values := fields.Values(); len(values) != 1 {
+		t.Errorf("expected only the filtered size class, got %v", values)
+	}
+
+	for key := range fields.SizeClasses {
+		if meta, ok := LookupFieldMeta(key); !ok || meta.Kind != Counter {
+			t.Errorf("expected counter metadata for (%s), got %v", key, meta)
+		}
+	}
+
+	for _, key := range []string{"mem.bysize.16", "mem.bysize.x.mallocs", "mem.bysize.16.allocs"} {
+		if isSizeClassKey(key) {
+			t.Errorf("expected (%s) not to be a size class key", key)
+		}
+	}
+}
+
+func TestCollectorPausePercentiles(t *testing.T) {
+	runtime.GC()
+	runtime.GC()
+
+	c := New(nil)
+	c.PausePercentiles = []float64{50, 99.9}
+
+	fields := c.OneOff()
+	values := fields.Values()
+
+	for _, expKey := range []string{"mem.gc.pause_min", "mem.gc.pause_max", "mem.gc.pause_p50", "mem.gc.pause_p99_9"} {
+		if _, ok := values[expKey]; !ok {
+			t.Errorf("expected key (%s) not found", expKey)
+		}
+	}
+
+	if fields.PauseMin > fields.PauseMax {
+		t.Errorf("expected min (%d) <= max (%d)", fields.PauseMin, fields.PauseMax)
+	}
+
+	if p50 := fields.PausePercentiles["mem.gc.pause_p50"]; p50 < fields.PauseMin || p50 > fields.PauseMax {
+		t.Errorf("expected p50 (%d) between min (%d) and max (%d)", p50, fields.PauseMin, fields.PauseMax)
+	}
+}
+
+func TestPercentileIndex(t *testing.T) {
+	tests := []struct {
+		n   int
+		p   float64
+		exp int
+	}{
+		{1, 99, 0},
+		{10, 50, 4},
+		{10, 95, 9},
+		{100, 95, 94},
+		{256, 0, 0},
+		{256, 100, 255},
+	}
+
+	for _, test := range tests {
+		if i := percentileIndex(test.n, test.p); i != test.exp {
+			t.Errorf("percentileIndex(%d, %v): got %d exp %d", test.n, test.p, i, test.exp)
+		}
+	}
+}
+
+func TestCollectorSetTags(t *testing.T) {
+	c := New(nil)
+	c.SetTags(map[string]string{"service": "api", "go.os": "custom"})
+
+	fields := c.OneOff()
+	tags := fields.Tags()
+
+	if tags["service"] != "api" {
+		t.Errorf("expected tag (service) to be (api) got (%s)", tags["service"])
+	}
+	if tags["go.os"] != "custom" {
+		t.Errorf("expected user tag to replace go.os, got (%s)", tags["go.os"])
+	}
+	if tags["go.arch"] == "" {
+		t.Errorf("expected tag (go.arch) not found")
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	var first, second int
+	done := make(chan struct{})
+
+	c := NewWithOptions(func(Fields) { first++ },
+		WithSink(func(Fields) { second++ }),
+		WithPauseDur(time.Second),
+		WithCPU(false),
+		WithGC(false),
+		WithDerived(true),
+		WithFieldFilter("mem.heap.alloc"),
+		WithTags(map[string]string{"service": "test"}),
+		WithDone(done),
+	)
+
+	if c.PauseDur != time.Second || c.EnableCPU || !c.EnableMem || c.EnableGC || !c.EnableDerived || c.Done == nil {
+		t.Errorf("unexpected configuration: %+v", c)
+	}
+
+	c.emit(c.OneOff())
+	if first != 1 || second != 1 {
+		t.Errorf("expected both sinks to be called once, got %d and %d", first, second)
+	}
+
+	fields := c.OneOff()
+	if values := fields.Values(); len(values) != 1 || fields.Tags()["service"] != "test" {
+		t.Errorf("unexpected values %v and tags %v", values, fields.Tags())
+	}
+}
+
+func TestCollectorBuildInfo(t *testing.T) {
+	fields := New(nil).OneOff()
+	if _, ok := fields.Tags()["build.revision"]; ok {
+		t.Error("expected no build tags by default")
+	}
+
+	c := New(nil)
+	c.EnableBuildInfo = true
+
+	// Test binaries carry no VCS information, so only check the values match.
+	fields = c.OneOff()
+	build := readBuildInfo()
+	if fields.BuildVersion != build.version || fields.BuildRevision != build.revision || fields.BuildTime != build.time {
+		t.Errorf("unexpected build info %+v", fields)
+	}
+
+	fields = Fields{BuildRevision: "abc"}
+	if tags := fields.Tags(); tags["build.revision"] != "abc" {
+		t.Errorf("expected tag build.revision (abc), got %v", tags)
+	}
+	if _, ok := fields.Tags()["build.version"]; ok {
+		t.Error("expected unknown build.version to be omitted")
+	}
+}
+
+func TestCollectorStartEpoch(t *testing.T) {
+	fields := New(nil).OneOff()
+	if _, ok := fields.Tags()["proc.start_epoch"]; ok {
+		t.Error("expected no proc.start_epoch tag by default")
+	}
+
+	c := New(nil)
+	c.EnableStartEpoch = true
+
+	fields = c.OneOff()
+	first := fields.Tags()["proc.start_epoch"]
+	epoch, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || epoch <= 0 || epoch > time.Now().Unix() {
+		t.Errorf("expected the start time as tag proc.start_epoch, got %q", first)
+	}
+	fields = NewWithOptions(nil, WithStartEpoch(true)).OneOff()
+	if second := fields.Tags()["proc.start_epoch"]; second != first {
+		t.Errorf("expected every collector to tag the same start, got %q and %q", first, second)
+	}
+}
+
+func TestCollectorHistory(t *testing.T) {
+	c := New(nil)
+	if c.History() != nil {
+		t.Error("expected no history by default")
+	}
+
+	c.HistorySize = 3
+	for i := 1; i <= 5; i++ {
+		c.emit(Fields{NumGoroutine: int64(i)})
+	}
+
+	history := c.History()
+	if len(history) != 3 {
+		t.Fatalf("expected 3 retained collections, got %d", len(history))
+	}
+
+	for i, fields := range history {
+		if exp := int64(i + 3); fields.NumGoroutine != exp {
+			t.Errorf("expected collection %d to be (%d), got (%d)", i, exp, fields.NumGoroutine)
+		}
+	}
+}
+
+func TestCollectorSinks(t *testing.T) {
+	var first, second, third int
+	c := New(func(Fields) { first++ }, nil, func(Fields) { second++ })
+	c.AddSink(func(Fields) { third++ })
+	c.PauseDur = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.AddSink(func(Fields) { cancel() })
+	c.RunContext(ctx)
+
+	if first != 1 || second != 1 || third != 1 {
+		t.Errorf("expected every sink to be called once, got (%d, %d, %d)", first, second, third)
+	}
+}
+
+func TestCollectorProcess(t *testing.T) {
+	c := New(nil)
+	c.EnableProcess = true
+
+	fields := c.OneOff()
+	if fields.ProcRSS < 0 || fields.ProcOpenFDs < 0 {
+		t.Errorf("expected non-negative proc.rss and proc.open_fds, got (%d, %d)", fields.ProcRSS, fields.ProcOpenFDs)
+	}
+	if fields.ProcCPUUser < 0 || fields.ProcCPUSystem < 0 {
+		t.Errorf("expected non-negative cpu times, got (%d, %d)", fields.ProcCPUUser, fields.ProcCPUSystem)
+	}
+
+	if _, err := os.Stat("/proc/self"); err != nil && runtime.GOOS != "windows" {
+		return
+	}
+
+	if fields.ProcRSS <= 0 {
+		t.Errorf("expected positive proc.rss, got %d", fields.ProcRSS)
+	}
+	if fields.ProcOpenFDs <= 0 {
+		t.Errorf("expected positive proc.open_fds, got %d", fields.ProcOpenFDs)
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	NewJSONLogger(buf)(New(nil).OneOff())
+	(&JSONLogger{Writer: buf}).Log(New(nil).OneOff())
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines got %d", len(lines))
+	}
+
+	for i, line := range lines {
+		values := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &values); err != nil {
+			t.Fatalf("invalid json line: %v", err)
+		}
+
+		for _, expKey := range []string{"cpu.goroutines", "mem.lookups", "time"} {
+			if _, ok := values[expKey]; !ok {
+				t.Errorf("expected key (%s) not found", expKey)
+			}
+		}
+
+		if _, ok := values["go.version"]; ok != (i == 0) {
+			t.Errorf("unexpected presence of key (go.version) in line %d: %v", i, ok)
+		}
+	}
+}
+
+func TestCollectorSnapshot(t *testing.T) {
+	c := New(nil)
+
+	first := c.Snapshot()
+	if first.Mallocs <= 0 {
+		t.Errorf("expected the first snapshot to report all mallocs, got %d", first.Mallocs)
+	}
+
+	runtime.GC()
+	second := c.Snapshot()
+
+	if second.NumGC < 1 {
+		t.Errorf("expected a mem.gc.count delta of at least 1, got %d", second.NumGC)
+	}
+	if second.Mallocs < 0 {
+		t.Errorf("expected a non-negative mem.malloc delta, got %d", second.Mallocs)
+	}
+	if second.HeapSys <= 0 {
+		t.Errorf("expected gauge mem.heap.sys to stay absolute, got %d", second.HeapSys)
+	}
+
+	f := Fields{FinalizersQueued: 5, FinalizersExecuted: 4, FinalizersPending: 1, CleanupsQueued: 3, CleanupsExecuted: 3}
+	subtractCounters(&f, &Fields{FinalizersQueued: 2, FinalizersExecuted: 2, FinalizersPending: 1, CleanupsQueued: 1, CleanupsExecuted: 1})
+	if f.FinalizersQueued != 3 || f.FinalizersExecuted != 2 || f.CleanupsQueued != 2 || f.CleanupsExecuted != 2 {
+		t.Errorf("expected the finalizer and cleanup counters to be subtracted, got %+v", f)
+	}
+	if f.FinalizersPending != 1 {
+		t.Errorf("expected gauge mem.gc.finalizers.pending to stay absolute, got %d", f.FinalizersPending)
+	}
+}
+
+func TestSubtractCountersMatchesMeta(t *testing.T) {
+	var f, prev Fields
+	fv, pv := reflect.ValueOf(&f).Elem(), reflect.ValueOf(&prev).Elem()
+	for i := 0; i < fv.NumField(); i++ {
+		if fv.Field(i).Kind() == reflect.Int64 {
+			fv.Field(i).SetInt(3)
+			pv.Field(i).SetInt(1)
+		}
+	}
+
+	subtractCounters(&f, &prev)
+
+	for key, value := range f.Values() {
+		n, ok := value.(int64)
+		meta, known := LookupFieldMeta(key)
+		if !ok || !known {
+			continue
+		}
+
+		if meta.Kind == Counter && n != 2 {
+			t.Errorf("expected counter (%s) to be subtracted, got %d", key, n)
+		}
+		if meta.Kind == Gauge && n != 3 {
+			t.Errorf("expected gauge (%s) to stay absolute, got %d", key, n)
+		}
+	}
+
+	if f.NumOSThread != 2 {
+		t.Errorf("expected counter (cpu.os_threads) to be subtracted, got %d", f.NumOSThread)
+	}
+}
+
+func TestCollectorSnapshotSizeClasses(t *testing.T) {
+	c := New(nil)
+	c.EnableSizeClasses = true
+
+	first := c.Snapshot()
+	mallocs := first.SizeClasses["mem.bysize.16.mallocs"]
+
+	sink := make([][]byte, 100)
+	for i := range sink {
+		sink[i] = make([]byte, 16)
+	}
+	runtime.KeepAlive(sink)
+
+	second := c.Snapshot()
+	if delta := second.SizeClasses["mem.bysize.16.mallocs"]; delta < 0 || delta >= mallocs+int64(len(sink)) {
+		t.Errorf("expected a delta of the 16 byte mallocs, got %d after %d", delta, mallocs)
+	}
+	if first.SizeClasses["mem.bysize.16.mallocs"] != mallocs {
+		t.Error("expected the first snapshot to be left unchanged")
+	}
+}
+
+func TestCollectorContention(t *testing.T) {
+	defer runtime.SetBlockProfileRate(0)
+	runtime.SetBlockProfileRate(1)
+
+	ch := make(chan struct{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(ch)
+	}()
+	<-ch
+
+	c := New(nil)
+	c.EnableContention = true
+
+	if fields := c.OneOff(); fields.BlockTotal <= 0 {
+		t.Errorf("expected positive sync.block_total, got %d", fields.BlockTotal)
+	}
+}
+
+var allocSink []byte
+
+func TestCollectorDerived(t *testing.T) {
+	c := New(nil)
+	c.EnableDerived = true
+
+	if fields := c.OneOff(); fields.AllocRate != 0 || fields.GCRate != 0 || fields.HeapObjectsRate != 0 {
+		t.Errorf("expected zero rates on the first collection, got (%v, %v, %v)", fields.AllocRate, fields.GCRate, fields.HeapObjectsRate)
+	}
+
+	allocSink = make([]byte, 1<<20)
+	runtime.GC()
+	time.Sleep(10 * time.Millisecond)
+
+	fields := c.OneOff()
+	if fields.AllocRate <= 0 {
+		t.Errorf("expected positive mem.alloc_rate, got %v", fields.AllocRate)
+	}
+	if fields.GCRate <= 0 {
+		t.Errorf("expected positive mem.gc.rate, got %v", fields.GCRate)
+	}
+}
+
+var objectSink []*int
+
+func TestCollectorHeapObjectsRate(t *testing.T) {
+	c := New(nil)
+	c.EnableDerived = true
+	c.OneOff()
+
+	objectSink = make([]*int, 100000)
+	for i := range objectSink {
+		objectSink[i] = new(int)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if fields := c.OneOff(); fields.HeapObjectsRate <= 0 {
+		t.Errorf("expected positive mem.heap.objects_rate, got %v", fields.HeapObjectsRate)
+	}
+	objectSink = nil
+}
+
+func TestJittered(t *testing.T) {
+	if d := Jittered(time.Second, 0); d != time.Second {
+		t.Errorf("expected no jitter, got %v", d)
+	}
+
+	for i := 0; i < 100; i++ {
+		if d := Jittered(time.Second, 100*time.Millisecond); d < 900*time.Millisecond || d > 1100*time.Millisecond {
+			t.Errorf("jittered duration out of range: %v", d)
+		}
+		if d := Jittered(time.Second, time.Hour); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Errorf("expected jitter to be capped, got %v", d)
+		}
+	}
+}
+
+func TestCollectorForcedGC(t *testing.T) {
+	runtime.GC()
+
+	fields := New(nil).OneOff()
+	if fields.NumForcedGC <= 0 {
+		t.Errorf("expected positive mem.gc.num_forced, got %d", fields.NumForcedGC)
+	}
+	if fields.LastGCAge <= 0 || fields.LastGCAge > int64(time.Minute) {
+		t.Errorf("expected a recent mem.gc.age_ns, got %d", fields.LastGCAge)
+	}
+}
+
+func TestFieldsValuesInto(t *testing.T) {
+	fields := New(nil).OneOff()
+	values := fields.Values()
+
+	m := map[string]interface{}{"mem.alloc": "stale"}
+	if got := fields.ValuesInto(m); len(got) != len(values) {
+		t.Fatalf("expected %d keys got %d", len(values), len(got))
+	}
+
+	for key, value := range values {
+		if m[key] != value {
+			t.Errorf("unexpected value of key (%s): %v != %v", key, m[key], value)
+		}
+	}
+}
+
+func BenchmarkFieldsValues(b *testing.B) {
+	fields := New(nil).OneOff()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = fields.Values()
+	}
+}
+
+func BenchmarkFieldsValuesInto(b *testing.B) {
+	fields := New(nil).OneOff()
+	m := make(map[string]interface{})
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = fields.ValuesInto(m)
+	}
+}
+
+func TestCollectorMemSampleEvery(t *testing.T) {
+	c := New(nil)
+	c.MemSampleEvery = 3
+
+	first := c.OneOff()
+	runtime.GC()
+
+	if second := c.OneOff(); second.NumGC != first.NumGC {
+		t.Errorf("expected memory statistics to be repeated, got %d GCs after %d", second.NumGC, first.NumGC)
+	}
+
+	c.OneOff()
+
+	if fourth := c.OneOff(); fourth.NumGC <= first.NumGC {
+		t.Errorf("expected memory statistics to be read again, got %d GCs after %d", fourth.NumGC, first.NumGC)
+	}
+}
+
+func TestCollectorHeapFragmentation(t *testing.T) {
+	fields := New(nil).OneOff()
+
+	if fields.HeapFragmentation <= 0 || fields.HeapFragmentation > 1 {
+		t.Errorf("expected heap fragmentation in (0, 1], got %f", fields.HeapFragmentation)
+	}
+
+	if exp := fields.HeapIdle - fields.HeapReleased; fields.HeapUnreleased != exp {
+		t.Errorf("expected unreleased heap of %d, got %d", exp, fields.HeapUnreleased)
+	}
+}
+
+func TestCollectorHeapGoalRatio(t *testing.T) {
+	m := runtime.MemStats{HeapAlloc: 3 << 20, NextGC: 4 << 20}
+	fields := NewWithMemStats(m).OneOff()
+
+	if fields.HeapGoalRatio != 0.75 {
+		t.Errorf("expected mem.gc.heap_goal_ratio (0.75), got %v", fields.HeapGoalRatio)
+	}
+
+	if fields := NewWithMemStats(runtime.MemStats{HeapAlloc: 1}).OneOff(); fields.HeapGoalRatio != 0 {
+		t.Errorf("expected zero ratio without a heap goal, got %v", fields.HeapGoalRatio)
+	}
+}
+
+func TestCollectorGOMAXPROCSChange(t *testing.T) {
+	var changes [][2]int64
+	c := New(nil)
+	c.OnGOMAXPROCSChange = func(prev, cur int64) { changes = append(changes, [2]int64{prev, cur}) }
+
+	procs := runtime.GOMAXPROCS(0)
+	defer runtime.GOMAXPROCS(procs)
+
+	c.OneOff()
+	c.OneOff()
+	if len(changes) != 0 {
+		t.Fatalf("expected no change to be reported, got %v", changes)
+	}
+
+	runtime.GOMAXPROCS(procs + 1)
+	if fields := c.OneOff(); fields.GOMAXPROCS != int64(procs+1) {
+		t.Errorf("expected cpu.gomaxprocs (%d), got (%d)", procs+1, fields.GOMAXPROCS)
+	}
+	if len(changes) != 1 || changes[0] != [2]int64{int64(procs), int64(procs + 1)} {
+		t.Errorf("expected the change from %d to %d, got %v", procs, procs+1, changes)
+	}
+}
+
+func TestCollectorOneOffAsync(t *testing.T) {
+	c := New(nil)
+
+	select {
+	case fields := <-c.OneOffAsync():
+		if fields.NumGoroutine <= 0 {
+			t.Errorf("expected positive cpu.goroutines, got %d", fields.NumGoroutine)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the result to be delivered")
+	}
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		c.OneOffAsync()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("expected unread results not to leak goroutines, got %d before and %d after", before, after)
+	}
+}
+
+func TestCollectorFilteredSink(t *testing.T) {
+	var all, cpu, gc map[string]interface{}
+
+	c := New(func(fields Fields) { all = fields.Values() })
+	c.AddFilteredSink(func(fields Fields) { cpu = fields.Values() }, "cpu.", "sched.")
+	c.AddFilteredSink(func(fields Fields) { gc = fields.Values() }, "mem.gc.")
+	c.emit(c.OneOff())
+
+	if _, ok := all["mem.heap.alloc"]; !ok {
+		t.Error("expected the unfiltered sink to receive all statistics")
+	}
+	for key := range cpu {
+		if !strings.HasPrefix(key, "cpu.") && !strings.HasPrefix(key, "sched.") {
+			t.Errorf("unexpected key (%s) for the CPU sink", key)
+		}
+	}
+	if _, ok := cpu["sched.threads"]; !ok || len(cpu) == 0 {
+		t.Errorf("expected the CPU statistics, got %v", cpu)
+	}
+	if _, ok := gc["mem.gc.pause_p99"]; !ok || len(gc) >= len(all) {
+		t.Errorf("expected only the GC statistics, got %v", gc)
+	}
+
+	c.FieldFilter = []string{"cpu.goroutines", "mem.heap.alloc"}
+	c.emit(c.OneOff())
+	if len(cpu) != 1 || cpu["cpu.goroutines"] == nil || len(gc) != 0 {
+		t.Errorf("expected the filters to apply on top of FieldFilter, got %v and %v", cpu, gc)
+	}
+}
+
+func TestCollectorFieldFilter(t *testing.T) {
+	c := New(nil)
+	c.FieldFilter = []string{"cpu.goroutines", "mem.heap.alloc", "mem.gc.pause_p99"}
+
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	fields := c.OneOff()
+	if values := fields.Values(); len(values) != 3 {
+		t.Errorf("expected only the filtered keys, got %v", values)
+	}
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(b, &values); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := values["mem.lookups"]; ok || len(values) != 3 {
+		t.Errorf("expected only the filtered keys in JSON, got %v", values)
+	}
+
+	c.FieldFilter = append(c.FieldFilter, "mem.unknown")
+	if err := c.Validate(); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+
+	if err := c.RunContext(context.Background()); err == nil {
+		t.Error("expected RunContext to return the validation error")
+	}
+
+	defer func() {
+		if err, ok := recover().(error); !ok || !strings.Contains(err.Error(), "mem.unknown") {
+			t.Errorf("expected Run to panic with the validation error, got %v", err)
+		}
+	}()
+	c.Run()
+}
+
+func TestCollectorGoroutineStates(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	for i := 0; i < 3; i++ {
+		go func() { <-block }()
+	}
+
+	c := New(nil)
+	c.EnableGoroutineStates = true
+
+	// Give the goroutines time to block.
+	time.Sleep(10 * time.Millisecond)
+
+	fields := c.OneOff()
+	if fields.GoroutinesRunning < 1 {
+		t.Errorf("expected at least the collecting goroutine to be running, got %d", fields.GoroutinesRunning)
+	}
+	if fields.GoroutinesChan < 3 {
+		t.Errorf("expected at least 3 goroutines blocked on a channel, got %d", fields.GoroutinesChan)
+	}
+}
+
+func TestGoroutineStatesCount(t *testing.T) {
+	s := goroutineStates{}
+	for _, state := range []string{"running", "IO wait", "select", "chan send", "sync.Mutex.Lock", "GC worker (idle)"} {
+		s.count(state)
+	}
+
+	if s.Running != 1 || s.IOWait != 1 || s.Select != 1 || s.Chan != 1 || s.Sync != 1 || s.Waiting != 1 {
+		t.Errorf("unexpected counts: %+v", s)
+	}
+}
+
+func TestCollectorSetPauseDur(t *testing.T) {
+	var mu sync.Mutex
+	collections := 0
+
+	c := New(func(Fields) {
+		mu.Lock()
+		collections++
+		mu.Unlock()
+	})
+	c.PauseDur = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		c.RunContext(ctx)
+	}()
+
+	c.SetPauseDur(10 * time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	<-stopped
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if collections < 5 {
+		t.Errorf("expected the new interval to be used, got %d collections", collections)
+	}
+}
+
+func TestCollectorTrigger(t *testing.T) {
+	collected := make(chan struct{}, 10)
+	trigger := make(chan struct{})
+
+	c := New(func(Fields) { collected <- struct{}{} })
+	c.PauseDur = 0
+	c.Trigger = trigger
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		c.RunContext(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	<-collected
+	for i := 0; i < 3; i++ {
+		trigger <- struct{}{}
+		<-collected
+	}
+
+	close(trigger)
+	time.Sleep(20 * time.Millisecond)
+	if n := len(collected); n != 0 {
+		t.Errorf("expected collections only on trigger, got %d more", n)
+	}
+
+	c.SetPauseDur(5 * time.Millisecond)
+	select {
+	case <-collected:
+	case <-time.After(time.Second):
+		t.Error("expected SetPauseDur to start the ticker")
+	}
+
+	if err := New().Validate(); err != nil {
+		t.Error(err)
+	}
+	if err := (&Collector{}).Validate(); err == nil {
+		t.Error("expected an error without PauseDur and Trigger")
+	}
+}
+
+func TestCollectorGCWithoutMem(t *testing.T) {
+	c := New(nil)
+	c.EnableMem = false
+
+	if err := c.Validate(); err != nil {
+		t.Errorf("expected EnableGC without EnableMem to be accepted, got %v", err)
+	}
+
+	fields := c.OneOff()
+	if fields.NumGoroutine <= 0 || fields.NumGC != 0 {
+		t.Errorf("expected CPU but no GC statistics, got %+v", fields)
+	}
+
+	if c := NewWithOptions(nil, WithMem(false)); c.EnableGC {
+		t.Error("expected WithMem(false) to disable EnableGC")
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	done := make(chan struct{})
+	close(done)
+
+	c = &Collector{PauseDur: time.Hour, EnableMem: false, EnableGC: true, Done: done}
+	if err := c.RunContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.EnableGC || !strings.Contains(logged.String(), "disabling EnableGC") {
+		t.Errorf("expected RunContext to disable EnableGC with a warning, got %v and %q", c.EnableGC, logged.String())
+	}
+}
+
+func TestCollectorPauseResume(t *testing.T) {
+	var mu sync.Mutex
+	collections := 0
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return collections
+	}
+
+	c := New(func(Fields) {
+		mu.Lock()
+		collections++
+		mu.Unlock()
+	})
+	c.PauseDur = 5 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		c.RunContext(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	c.Pause()
+	time.Sleep(20 * time.Millisecond)
+
+	paused := count()
+	if paused == 0 {
+		t.Fatal("expected collections before pausing")
+	}
+
+	if fields := c.OneOff(); fields.NumGoroutine <= 0 {
+		t.Error("expected OneOff to work while paused")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n := count(); n != paused {
+		t.Errorf("expected no collections while paused, got %d", n-paused)
+	}
+
+	c.Resume()
+	time.Sleep(50 * time.Millisecond)
+	if n := count(); n <= paused {
+		t.Error("expected collections to restart after resuming")
+	}
+}
+
+func TestAdaptivePauseDur(t *testing.T) {
+	const minDur, maxDur = time.Second, time.Minute
+
+	a := &adaptiveState{cur: 10 * time.Second}
+	now := time.Now()
+	fields := Fields{}
+
+	if d := a.next(&fields, now, minDur, maxDur); d != 10*time.Second {
+		t.Errorf("expected PauseDur on the first collection, got %v", d)
+	}
+
+	// A steady allocation rate lengthens the interval up to max.
+	for i := 0; i < 20; i++ {
+		now = now.Add(a.cur)
+		fields.TotalAlloc += int64(a.cur / time.Millisecond)
+		a.next(&fields, now, minDur, maxDur)
+	}
+	if a.cur != maxDur {
+		t.Errorf("expected a steady rate to reach the maximum, got %v", a.cur)
+	}
+
+	// A spike halves the interval down to min.
+	for i := 0; i < 20; i++ {
+		now = now.Add(a.cur)
+		fields.TotalAlloc += int64(a.cur) * int64(i+2) * 10
+		a.next(&fields, now, minDur, maxDur)
+	}
+	if a.cur != minDur {
+		t.Errorf("expected spikes to reach the minimum, got %v", a.cur)
+	}
+}
+
+func TestCollectorOnPauseExceeded(t *testing.T) {
+	var pauses []int64
+
+	c := New(nil)
+	c.OnPauseExceeded = func(pauseNs int64) { pauses = append(pauses, pauseNs) }
+	runtime.GC()
+	c.OneOff()
+
+	if len(pauses) != 0 {
+		t.Errorf("expected the pauses before the first collection to be ignored, got %v", pauses)
+	}
+
+	runtime.GC()
+	runtime.GC()
+	c.OneOff()
+
+	if len(pauses) < 2 {
+		t.Errorf("expected both GC pauses to be reported, got %v", pauses)
+	}
+
+	pauses = nil
+	c.OneOff()
+
+	if len(pauses) != 0 {
+		t.Errorf("expected pauses to be reported only once, got %v", pauses)
+	}
+
+	c.PauseThreshold = time.Hour
+	runtime.GC()
+	c.OneOff()
+
+	if len(pauses) != 0 {
+		t.Errorf("expected pauses below the threshold to be ignored, got %v", pauses)
+	}
+}
+
+func TestNewWithMemStats(t *testing.T) {
+	m := runtime.MemStats{HeapAlloc: 1024, NumGC: 3, HeapInuse: 50, HeapSys: 100}
+	m.PauseNs[2] = 500
+
+	c := NewWithMemStats(m)
+	c.EnableCPU = false
+
+	fields := c.OneOff()
+	if fields.HeapAlloc != 1024 || fields.NumGC != 3 || fields.PauseNs != 500 {
+		t.Errorf("expected the injected memory statistics, got %+v", fields)
+	}
+
+	if fields.HeapFragmentation != 0.5 {
+		t.Errorf("expected heap fragmentation of 0.5, got %f", fields.HeapFragmentation)
+	}
+
+	if fields := NewWithMemStats(runtime.MemStats{StackInuse: 25, HeapInuse: 100}).OneOff(); fields.StackHeapRatio != 0.25 {
+		t.Errorf("expected a stack to heap ratio of 0.25, got %f", fields.StackHeapRatio)
+	}
+	if fields := NewWithMemStats(runtime.MemStats{StackInuse: 25}).OneOff(); fields.StackHeapRatio != 0 {
+		t.Errorf("expected a zero ratio without heap in use, got %f", fields.StackHeapRatio)
+	}
+
+	if next := c.OneOff(); next.Values()["mem.heap.alloc"] != fields.Values()["mem.heap.alloc"] {
+		t.Error("expected every collection to report the injected statistics")
+	}
+}
+
+func TestFieldsFromMemStats(t *testing.T) {
+	m := &runtime.MemStats{HeapAlloc: 1024, NumGC: 3, HeapInuse: 50, HeapSys: 100}
+	m.PauseNs[0], m.PauseNs[1], m.PauseNs[2] = 100, 300, 200
+
+	fields := FieldsFromMemStats(m, CPUStats{NumGoroutine: 7, NumCpu: 2})
+	values := fields.Values()
+
+	for key, exp := range map[string]interface{}{
+		"cpu.goroutines":         int64(7),
+		"cpu.count":              int64(2),
+		"mem.heap.alloc":         int64(1024),
+		"mem.gc.count":           int64(3),
+		"mem.gc.pause":           int64(200),
+		"mem.gc.pause_max":       int64(300),
+		"mem.gc.pause_p50":       int64(200),
+		"mem.gc.age_ns":          int64(0),
+		"mem.alloc_rate":         float64(0),
+		"mem.heap.fragmentation": 0.5,
+	} {
+		if values[key] != exp {
+			t.Errorf("expected %s (%v), got (%v)", key, exp, values[key])
+		}
+	}
+
+	if again := FieldsFromMemStats(m, CPUStats{NumGoroutine: 7, NumCpu: 2}); !reflect.DeepEqual(again.Values(), values) {
+		t.Error("expected the same statistics to build the same fields")
+	}
+}
+
+func TestFieldsExtra(t *testing.T) {
+	fields := Fields{
+		NumGoroutine: 3,
+		Extra: map[string]interface{}{
+			"app.ratio":      0.25,
+			"app.release":    "v1.2.3",
+			"app.ready":      true,
+			"cpu.goroutines": int64(0),
+		},
+	}
+
+	values := fields.Values()
+	for key, exp := range map[string]interface{}{
+		"app.ratio":      0.25,
+		"app.release":    "v1.2.3",
+		"app.ready":      true,
+		"cpu.goroutines": int64(3),
+	} {
+		if values[key] != exp {
+			t.Errorf("expected %s (%v) got (%v)", key, exp, values[key])
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	write, _ := NewCSVWriter(buf)
+	write(fields)
+
+	rows, _ := csv.NewReader(buf).ReadAll()
+	for i, key := range rows[0] {
+		if key == "app.release" && rows[1][i] != "v1.2.3" {
+			t.Errorf("expected the string value in the csv, got %q", rows[1][i])
+		}
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	buf := &bytes.Buffer{}
+	NewWriterSink(buf, JSONSerializer{})(New(nil).OneOff())
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &values); err != nil {
+		t.Fatalf("invalid json line: %v", err)
+	}
+	for _, expKey := range []string{"cpu.goroutines", "go.version", "time"} {
+		if _, ok := values[expKey]; !ok {
+			t.Errorf("expected key (%s) not found", expKey)
+		}
+	}
+
+	var got error
+	fail := SerializerFunc(func(Fields, map[string]string, time.Time) ([]byte, error) {
+		return nil, errors.New("boom")
+	})
+	(&WriterSink{Writer: buf, Serializer: fail, OnError: func(err error) { got = err }}).Send(Fields{})
+	if got == nil || got.Error() != "boom" {
+		t.Errorf("expected the serializer error, got %v", got)
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	write, err := NewCSVWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(nil)
+	write(c.OneOff())
+	write(c.OneOff())
+
+	rows, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid csv: %v", err)
+	}
+
+	if len(rows) != 3 {
+		t.Fatalf("expected a header and 2 rows, got %d rows", len(rows))
+	}
+
+	header := rows[0]
+	if header[0] != "time" || !sort.StringsAreSorted(header[1:]) {
+		t.Errorf("expected time followed by the sorted keys, got %v", header)
+	}
+
+	for _, row := range rows[1:] {
+		if len(row) != len(header) {
+			t.Errorf("expected %d columns, got %d", len(header), len(row))
+		}
+	}
+
+	if _, err := NewCSVWriter(nil); err == nil {
+		t.Error("expected an error for a nil writer")
+	}
+}
+
+func TestFieldMetadata(t *testing.T) {
+	fields := New(nil).OneOff()
+	for key := range fields.Values() {
+		if _, ok := LookupFieldMeta(key); !ok {
+			t.Errorf("missing metadata for key (%s)", key)
+		}
+	}
+
+	if meta := FieldMetadata()["mem.gc.count"]; meta.Kind != Counter || meta.Unit != UnitCount {
+		t.Errorf("expected mem.gc.count to be a counter of count, got %+v", meta)
+	}
+
+	if meta, _ := LookupFieldMeta("mem.gc.pause_p99"); meta.Kind != Gauge || meta.Unit != UnitNanoseconds {
+		t.Errorf("expected pause percentiles to be gauges of ns, got %+v", meta)
+	}
+}
+
+func TestCollectorOneOffCPU(t *testing.T) {
+	s := New(nil).OneOffCPU()
+	if s.NumGoroutine < 1 || s.NumCpu < 1 || s.NumThread < 1 || s.NumOSThread < 1 {
+		t.Errorf("expected positive CPU statistics, got %+v", s)
+	}
+}
+
+func TestCollectorGOMAXPROCS(t *testing.T) {
+	prev := runtime.GOMAXPROCS(3)
+	defer runtime.GOMAXPROCS(prev)
+
+	fields := New(nil).OneOff()
+	if fields.GOMAXPROCS != 3 {
+		t.Errorf("expected cpu.gomaxprocs (3), got %d", fields.GOMAXPROCS)
+	}
+	if runtime.GOMAXPROCS(0) != 3 {
+		t.Error("expected GOMAXPROCS to be left unchanged")
+	}
+}
+
+func BenchmarkCollectorOneOffCPU(b *testing.B) {
+	c := New(nil)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = c.OneOffCPU()
+	}
+}
@@ -0,0 +1,33 @@
+package collector
+
+import "runtime"
+
+type contentionStats struct {
+	MutexWaitCycles int64
+	BlockCount      int64
+}
+
+// readContentionStats sums the records of the mutex and block profiles. The
+// profiles are only populated once enabled with runtime.SetMutexProfileFraction
+// and runtime.SetBlockProfileRate.
+func readContentionStats(s *contentionStats) {
+	for _, r := range readProfile(runtime.MutexProfile) {
+		s.MutexWaitCycles += r.Cycles
+	}
+
+	for _, r := range readProfile(runtime.BlockProfile) {
+		s.BlockCount += r.Count
+	}
+}
+
+func readProfile(profile func([]runtime.BlockProfileRecord) (int, bool)) []runtime.BlockProfileRecord {
+	n, _ := profile(nil)
+	for {
+		// Leave room for records added between the calls.
+		records := make([]runtime.BlockProfileRecord, n+16)
+		var ok bool
+		if n, ok = profile(records); ok {
+			return records[:n]
+		}
+	}
+}
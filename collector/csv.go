@@ -0,0 +1,87 @@
+package collector
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// csvWriter writes the statistics as CSV rows in the column order of the
+// header, which is taken from the keys of the first set of statistics.
+type csvWriter struct {
+	mu      sync.Mutex
+	w       *csv.Writer
+	columns []string
+}
+
+// NewCSVWriter returns a FieldsFunc which writes every set of statistics to w as
+// a row of CSV. A header row is written before the first row, holding a "time"
+// column with the RFC 3339 time of the write followed by the keys returned by
+// Fields.Values in sorted order. The columns are fixed by the first row, keys
+// missing from a later row are written as empty values and new keys are
+// ignored. Write errors are ignored.
+//
+//	package main
+//
+//	import (
+//	   "os"
+//	   "github.com/tevjef/go-runtime-metrics/collector"
+//	)
+//
+//	func main {
+//	    csv, err := collector.NewCSVWriter(os.Stdout)
+//	    if err != nil {
+//	        // handle error
+//	    }
+//	    collector.New(csv).Run()
+//	}
+func NewCSVWriter(w io.Writer) (FieldsFunc, error) {
+	if w == nil {
+		return nil, errors.New("collector: nil writer for CSV")
+	}
+
+	c := &csvWriter{w: csv.NewWriter(w)}
+	return c.write, nil
+}
+
+func (c *csvWriter) write(fields Fields) {
+	values := fields.Values()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.columns == nil {
+		c.columns = make([]string, 0, len(values))
+		for key := range values {
+			c.columns = append(c.columns, key)
+		}
+		sort.Strings(c.columns)
+
+		c.w.Write(append([]string{"time"}, c.columns...))
+	}
+
+	row := make([]string, 0, len(c.columns)+1)
+	row = append(row, time.Now().Format(time.RFC3339Nano))
+
+	for _, key := range c.columns {
+		switch v := values[key].(type) {
+		case int64:
+			row = append(row, strconv.FormatInt(v, 10))
+		case float64:
+			row = append(row, strconv.FormatFloat(v, 'g', -1, 64))
+		case bool:
+			row = append(row, strconv.FormatBool(v))
+		case string:
+			row = append(row, v)
+		default:
+			row = append(row, "")
+		}
+	}
+
+	c.w.Write(row)
+	c.w.Flush()
+}
@@ -0,0 +1,61 @@
+package collector
+
+import "time"
+
+// derivedState holds the counters of the previous collection used to compute
+// the derived rates.
+type derivedState struct {
+	at         time.Time
+	totalAlloc int64
+	numGC      int64
+	objects    int64
+	allocRate  float64
+	gcRate     float64
+	objectRate float64
+}
+
+// collectDerivedStats computes per second rates from the difference between the
+// counters of this and the previous collection. The rates are zero on the first
+// collection. now is the time the memory statistics were read, collections
+// repeating the previous memory statistics (see MemSampleEvery) repeat the
+// previous rates.
+func (c *Collector) collectDerivedStats(fields *Fields, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prev := c.derived
+	if prev != nil && now.Equal(prev.at) {
+		fields.AllocRate = prev.allocRate
+		fields.GCRate = prev.gcRate
+		fields.HeapObjectsRate = prev.objectRate
+		return
+	}
+
+	c.derived = &derivedState{
+		at:         now,
+		totalAlloc: fields.TotalAlloc,
+		numGC:      fields.NumGC,
+		objects:    fields.HeapObjects,
+	}
+
+	if prev == nil {
+		return
+	}
+
+	elapsed := now.Sub(prev.at).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	fields.AllocRate = float64(fields.TotalAlloc-prev.totalAlloc) / elapsed
+	// Unlike the cumulative counters above the number of objects drops when they
+	// are freed, so the rate can be negative.
+	fields.HeapObjectsRate = float64(fields.HeapObjects-prev.objects) / elapsed
+	if c.EnableGC {
+		fields.GCRate = float64(fields.NumGC-prev.numGC) / elapsed
+	}
+
+	c.derived.allocRate = fields.AllocRate
+	c.derived.gcRate = fields.GCRate
+	c.derived.objectRate = fields.HeapObjectsRate
+}
@@ -0,0 +1,46 @@
+package collector
+
+import "runtime/metrics"
+
+// Metrics read when EnableFinalizers is set, all require Go 1.25.
+const (
+	finalizersQueuedMetric   = "/gc/finalizers/queued:finalizers"
+	finalizersExecutedMetric = "/gc/finalizers/executed:finalizers"
+	cleanupsQueuedMetric     = "/gc/cleanups/queued:cleanups"
+	cleanupsExecutedMetric   = "/gc/cleanups/executed:cleanups"
+)
+
+// collectFinalizerStats reads the finalizer and cleanup queue counters. The
+// pending counts are derived by subtracting the executed from the queued
+// totals. The cleanup totals are approximate according to the runtime, so the
+// pending cleanups are an approximation too and may briefly be off by a few.
+func (_ *Collector) collectFinalizerStats(fields *Fields) {
+	samples := [...]metrics.Sample{
+		{Name: finalizersQueuedMetric},
+		{Name: finalizersExecutedMetric},
+		{Name: cleanupsQueuedMetric},
+		{Name: cleanupsExecutedMetric},
+	}
+	metrics.Read(samples[:])
+
+	values := [len(samples)]int64{}
+	for i, s := range samples {
+		if s.Value.Kind() == metrics.KindUint64 {
+			values[i] = int64(s.Value.Uint64())
+		}
+	}
+
+	fields.FinalizersQueued = values[0]
+	fields.FinalizersExecuted = values[1]
+	fields.FinalizersPending = pending(values[0], values[1])
+	fields.CleanupsQueued = values[2]
+	fields.CleanupsExecuted = values[3]
+	fields.CleanupsPending = pending(values[2], values[3])
+}
+
+func pending(queued, executed int64) int64 {
+	if executed > queued {
+		return 0
+	}
+	return queued - executed
+}
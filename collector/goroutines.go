@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"bytes"
+	"runtime"
+)
+
+// initialStackBuf is the initial size of the buffer holding all goroutine
+// stacks, it is doubled until the stacks fit.
+const initialStackBuf = 64 << 10
+
+type goroutineStates struct {
+	Running  int64
+	Runnable int64
+	Syscall  int64
+	IOWait   int64
+	Select   int64
+	Chan     int64
+	Sync     int64
+	Sleep    int64
+	Waiting  int64
+}
+
+// readGoroutineStates counts the goroutines by the state in the header of their
+// stack trace, e.g. "goroutine 7 [chan receive, 2 minutes]:". Dumping the stacks
+// of all goroutines stops the world for a duration proportional to the number
+// of goroutines.
+func readGoroutineStates(s *goroutineStates) {
+	buf := make([]byte, initialStackBuf)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	for len(buf) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+			line, buf = buf[:i], buf[i+1:]
+		} else {
+			line, buf = buf, nil
+		}
+
+		if !bytes.HasPrefix(line, []byte("goroutine ")) {
+			continue
+		}
+
+		start, end := bytes.IndexByte(line, '['), bytes.LastIndexByte(line, ']')
+		if start < 0 || end < start {
+			continue
+		}
+
+		state := line[start+1 : end]
+		// Strip the wait duration and ", locked to thread".
+		if i := bytes.IndexByte(state, ','); i >= 0 {
+			state = state[:i]
+		}
+
+		s.count(string(state))
+	}
+}
+
+func (s *goroutineStates) count(state string) {
+	switch state {
+	case "running":
+		s.Running++
+	case "runnable":
+		s.Runnable++
+	case "syscall":
+		s.Syscall++
+	case "IO wait":
+		s.IOWait++
+	case "select", "select (no cases)":
+		s.Select++
+	case "chan receive", "chan send", "chan receive (nil chan)", "chan send (nil chan)":
+		s.Chan++
+	case "semacquire", "sync.Mutex.Lock", "sync.RWMutex.Lock", "sync.RWMutex.RLock",
+		"sync.Cond.Wait", "sync.WaitGroup.Wait":
+		s.Sync++
+	case "sleep":
+		s.Sleep++
+	default:
+		s.Waiting++
+	}
+}
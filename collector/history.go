@@ -0,0 +1,35 @@
+package collector
+
+// record appends fields to the history ring buffer when HistorySize is set.
+func (c *Collector) record(fields Fields) {
+	if c.HistorySize <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.history) < c.HistorySize {
+		c.history = append(c.history, fields)
+		return
+	}
+
+	c.history[c.historyNext] = fields
+	c.historyNext = (c.historyNext + 1) % len(c.history)
+}
+
+// History returns the statistics of the most recent collections of Run, up to
+// HistorySize, ordered from the oldest to the newest. It returns nil when
+// HistorySize is not set. It is safe to call while the Collector is running.
+func (c *Collector) History() []Fields {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.history) == 0 {
+		return nil
+	}
+
+	history := make([]Fields, 0, len(c.history))
+	history = append(history, c.history[c.historyNext:]...)
+	return append(history, c.history[:c.historyNext]...)
+}
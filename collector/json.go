@@ -0,0 +1,49 @@
+package collector
+
+import (
+	"io"
+	"time"
+)
+
+// JSONLogger writes every set of statistics to Writer as a single line of JSON
+// containing the keys returned by Fields.Values and a "time" key holding the
+// RFC 3339 time of the write. Write errors are ignored.
+type JSONLogger struct {
+	Writer io.Writer
+
+	// IncludeTags adds the keys returned by Fields.Tags, such as go.os, go.arch
+	// and go.version, to every line.
+	IncludeTags bool
+}
+
+// NewJSONLogger returns a FieldsFunc which writes every set of statistics to w as
+// a line of JSON, including the tags.
+//
+//	package main
+//
+//	import (
+//	   "os"
+//	   "github.com/tevjef/go-runtime-metrics/collector"
+//	)
+//
+//	func main {
+//	    collector.New(collector.NewJSONLogger(os.Stdout)).Run()
+//	}
+func NewJSONLogger(w io.Writer) FieldsFunc {
+	return (&JSONLogger{Writer: w, IncludeTags: true}).Log
+}
+
+// Log writes fields as a line of JSON. It matches the signature of FieldsFunc.
+func (l *JSONLogger) Log(fields Fields) {
+	var tags map[string]string
+	if l.IncludeTags {
+		tags = fields.Tags()
+	}
+
+	b, err := JSONSerializer{}.Serialize(fields, tags, time.Now())
+	if err != nil {
+		return
+	}
+
+	l.Writer.Write(b)
+}
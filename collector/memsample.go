@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"runtime"
+	"time"
+)
+
+// memSample holds the memory statistics repeated between reads when
+// MemSampleEvery is set.
+type memSample struct {
+	stats       *runtime.MemStats
+	at          time.Time
+	collections int
+}
+
+// readMemStats returns the memory statistics and the time they were read. With
+// MemSampleEvery set, runtime.ReadMemStats (or readMemStatsFunc) is only called
+// on every Nth call and the last statistics are returned otherwise.
+func (c *Collector) readMemStats() (*runtime.MemStats, time.Time) {
+	c.mu.Lock()
+	s := &c.memSample
+	s.collections++
+	if s.stats != nil && c.MemSampleEvery > 1 && (s.collections-1)%c.MemSampleEvery != 0 {
+		defer c.mu.Unlock()
+		return s.stats, s.at
+	}
+	c.mu.Unlock()
+
+	m := &runtime.MemStats{}
+	if c.readMemStatsFunc != nil {
+		c.readMemStatsFunc(m)
+	} else {
+		runtime.ReadMemStats(m)
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	s.stats = m
+	s.at = now
+	c.mu.Unlock()
+
+	return m, now
+}
+
+// NewWithMemStats creates a Collector like New that reports a copy of m instead
+// of reading the memory statistics of the runtime. It makes the output of the
+// memory and GC statistics deterministic, e.g. to test the formatting done by
+// sinks. Disable the other statistics, such as EnableCPU, as they are still read
+// from the runtime. mem.gc.age_ns depends on the current time unless
+// m.LastGC is zero.
+func NewWithMemStats(m runtime.MemStats, fieldsFuncs ...FieldsFunc) *Collector {
+	c := New(fieldsFuncs...)
+	c.readMemStatsFunc = func(dst *runtime.MemStats) {
+		*dst = m
+	}
+	return c
+}
+
+// FieldsFromMemStats builds the Fields the default settings of New output for
+// the memory statistics m and the CPU statistics cpu, without reading any
+// statistics of the runtime, e.g. to test sinks or to replay captured
+// statistics. The go.* tags describe the running binary, proc.up is 1 and the
+// statistics computed across collections, such as mem.alloc_rate, are zero.
+// mem.gc.age_ns depends on the current time unless m.LastGC is zero.
+func FieldsFromMemStats(m *runtime.MemStats, cpu CPUStats) Fields {
+	c := New()
+	fields := Fields{}
+
+	c.collectCPUStats(&fields, &cpu)
+	c.collectMemStats(&fields, m)
+	c.collectGCStats(&fields, m)
+
+	fields.Up = 1
+	fields.Goos = runtime.GOOS
+	fields.Goarch = runtime.GOARCH
+	fields.Version = runtime.Version()
+
+	return fields
+}
@@ -0,0 +1,160 @@
+package collector
+
+import "strings"
+
+// Kind tells how the value of a statistic behaves over time.
+type Kind int
+
+const (
+	// Gauge is a value that can go up and down, e.g. mem.heap.alloc.
+	Gauge Kind = iota
+
+	// Counter is a value that only increases while the process is running, e.g.
+	// mem.gc.count.
+	Counter
+)
+
+func (k Kind) String() string {
+	if k == Counter {
+		return "counter"
+	}
+	return "gauge"
+}
+
+// Units of the statistics
+const (
+	UnitBytes          = "bytes"
+	UnitBytesPerSecond = "bytes/s"
+	UnitCount          = "count"
+	UnitCountPerSecond = "count/s"
+	UnitCycles         = "cycles"
+	UnitNanoseconds    = "ns"
+	UnitRatio          = "ratio"
+	UnitSeconds        = "s"
+)
+
+// FieldMeta describes a statistic returned by Fields.Values.
+type FieldMeta struct {
+	// Unit is one of the Unit constants. Timestamps, such as mem.gc.last, are
+	// nanoseconds since the Unix epoch.
+	Unit string
+
+	Kind Kind
+}
+
+var fieldMeta = map[string]FieldMeta{
+	"cpu.count":      {UnitCount, Gauge},
+	"cpu.goroutines": {UnitCount, Gauge},
+	"cpu.cgo_calls":  {UnitCount, Counter},
+	"cpu.gomaxprocs": {UnitCount, Gauge},
+	"cpu.os_threads": {UnitCount, Counter},
+
+	"cpu.goroutines.max":      {UnitCount, Gauge},
+	"cpu.goroutines.running":  {UnitCount, Gauge},
+	"cpu.goroutines.runnable": {UnitCount, Gauge},
+	"cpu.goroutines.syscall":  {UnitCount, Gauge},
+	"cpu.goroutines.io_wait":  {UnitCount, Gauge},
+	"cpu.goroutines.select":   {UnitCount, Gauge},
+	"cpu.goroutines.chan":     {UnitCount, Gauge},
+	"cpu.goroutines.sync":     {UnitCount, Gauge},
+	"cpu.goroutines.sleep":    {UnitCount, Gauge},
+	"cpu.goroutines.waiting":  {UnitCount, Gauge},
+
+	"sched.threads":            {UnitCount, Gauge},
+	"sched.goroutines_waiting": {UnitCount, Gauge},
+	"sched.latency_p50":        {UnitNanoseconds, Gauge},
+	"sched.latency_p99":        {UnitNanoseconds, Gauge},
+
+	"mem.alloc":   {UnitBytes, Gauge},
+	"mem.total":   {UnitBytes, Counter},
+	"mem.sys":     {UnitBytes, Gauge},
+	"mem.lookups": {UnitCount, Counter},
+	"mem.malloc":  {UnitCount, Counter},
+	"mem.frees":   {UnitCount, Counter},
+
+	"mem.heap.alloc":         {UnitBytes, Gauge},
+	"mem.heap.sys":           {UnitBytes, Gauge},
+	"mem.heap.idle":          {UnitBytes, Gauge},
+	"mem.heap.inuse":         {UnitBytes, Gauge},
+	"mem.heap.released":      {UnitBytes, Gauge},
+	"mem.heap.objects":       {UnitCount, Gauge},
+	"mem.heap.unreleased":    {UnitBytes, Gauge},
+	"mem.heap.fragmentation": {UnitRatio, Gauge},
+
+	"mem.stack.inuse":        {UnitBytes, Gauge},
+	"mem.stack.sys":          {UnitBytes, Gauge},
+	"mem.stack.mspan_inuse":  {UnitBytes, Gauge},
+	"mem.stack.mspan_sys":    {UnitBytes, Gauge},
+	"mem.stack.mcache_inuse": {UnitBytes, Gauge},
+	"mem.stack.mcache_sys":   {UnitBytes, Gauge},
+	"mem.stack_heap_ratio":   {UnitRatio, Gauge},
+	"mem.othersys":           {UnitBytes, Gauge},
+
+	"mem.alloc_rate": {UnitBytesPerSecond, Gauge},
+	"mem.gc.rate":    {UnitCountPerSecond, Gauge},
+
+	"mem.heap.objects_rate": {UnitCountPerSecond, Gauge},
+
+	"mem.gc.sys":             {UnitBytes, Gauge},
+	"mem.gc.next":            {UnitBytes, Gauge},
+	"mem.gc.last":            {UnitNanoseconds, Gauge},
+	"mem.gc.pause_total":     {UnitNanoseconds, Counter},
+	"mem.gc.pause":           {UnitNanoseconds, Gauge},
+	"mem.gc.count":           {UnitCount, Counter},
+	"mem.gc.cpu_fraction":    {UnitRatio, Gauge},
+	"mem.gc.num_forced":      {UnitCount, Counter},
+	"mem.gc.age_ns":          {UnitNanoseconds, Gauge},
+	"mem.gc.pause_min":       {UnitNanoseconds, Gauge},
+	"mem.gc.pause_max":       {UnitNanoseconds, Gauge},
+	"mem.gc.heap_goal_ratio": {UnitRatio, Gauge},
+	"mem.gc.heap_live":       {UnitBytes, Gauge},
+
+	"mem.gc.finalizers.queued":   {UnitCount, Counter},
+	"mem.gc.finalizers.executed": {UnitCount, Counter},
+	"mem.gc.finalizers.pending":  {UnitCount, Gauge},
+	"mem.gc.cleanups.queued":     {UnitCount, Counter},
+	"mem.gc.cleanups.executed":   {UnitCount, Counter},
+	"mem.gc.cleanups.pending":    {UnitCount, Gauge},
+
+	"proc.up":             {UnitCount, Gauge},
+	"proc.uptime_seconds": {UnitSeconds, Gauge},
+	"proc.rss":            {UnitBytes, Gauge},
+	"proc.cpu_user":       {UnitNanoseconds, Counter},
+	"proc.cpu_system":     {UnitNanoseconds, Counter},
+	"proc.open_fds":       {UnitCount, Gauge},
+
+	"sync.mutex_wait_total": {UnitCycles, Counter},
+	"sync.block_total":      {UnitCount, Counter},
+	"sync.mutex_wait_ns":    {UnitNanoseconds, Counter},
+}
+
+// FieldMetadata returns the metadata of every statistic returned by
+// Fields.Values keyed by the statistic, except for the pause percentiles whose
+// keys depend on Collector.PausePercentiles, and the size classes. Use
+// LookupFieldMeta for those.
+func FieldMetadata() map[string]FieldMeta {
+	meta := make(map[string]FieldMeta, len(fieldMeta))
+	for key, m := range fieldMeta {
+		meta[key] = m
+	}
+	return meta
+}
+
+// LookupFieldMeta returns the metadata of the statistic key, including the
+// pause percentiles such as mem.gc.pause_p99 and the size classes such as
+// mem.bysize.16.mallocs. ok is false for unknown keys.
+func LookupFieldMeta(key string) (meta FieldMeta, ok bool) {
+	if meta, ok = fieldMeta[key]; ok {
+		return meta, true
+	}
+
+	if strings.HasPrefix(key, "mem.gc.pause_p") {
+		return FieldMeta{UnitNanoseconds, Gauge}, true
+	}
+
+	if isSizeClassKey(key) {
+		return FieldMeta{UnitCount, Counter}, true
+	}
+
+	return FieldMeta{}, false
+}
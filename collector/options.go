@@ -0,0 +1,173 @@
+package collector
+
+import "time"
+
+// Option configures a Collector created by NewWithOptions.
+type Option func(*Collector)
+
+// NewWithOptions creates a Collector like New that outputs statistics to
+// fieldsFunc and is fully configured by opts, applied in order on top of the
+// defaults. It is the preferred API over setting the exported fields, which
+// must not be changed once Run has been called, e.g.
+//
+//	c := collector.NewWithOptions(sink,
+//	    collector.WithPauseDur(time.Second),
+//	    collector.WithGC(false),
+//	)
+func NewWithOptions(fieldsFunc FieldsFunc, opts ...Option) *Collector {
+	c := New(fieldsFunc)
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithSink adds fieldsFunc as an additional sink, see AddSink.
+func WithSink(fieldsFunc FieldsFunc) Option {
+	return func(c *Collector) { c.AddSink(fieldsFunc) }
+}
+
+// WithFilteredSink adds fieldsFunc as an additional sink receiving only the
+// statistics with one of prefixes, see AddFilteredSink.
+func WithFilteredSink(fieldsFunc FieldsFunc, prefixes ...string) Option {
+	return func(c *Collector) { c.AddFilteredSink(fieldsFunc, prefixes...) }
+}
+
+// WithPauseDur sets PauseDur.
+func WithPauseDur(d time.Duration) Option {
+	return func(c *Collector) { c.PauseDur = d }
+}
+
+// WithAdaptivePauseDur sets MinPauseDur and MaxPauseDur.
+func WithAdaptivePauseDur(minDur, maxDur time.Duration) Option {
+	return func(c *Collector) {
+		c.MinPauseDur = minDur
+		c.MaxPauseDur = maxDur
+	}
+}
+
+// WithJitter sets Jitter.
+func WithJitter(jitter time.Duration) Option {
+	return func(c *Collector) { c.Jitter = jitter }
+}
+
+// WithGoroutinesMaxInterval sets GoroutinesMaxInterval.
+func WithGoroutinesMaxInterval(d time.Duration) Option {
+	return func(c *Collector) { c.GoroutinesMaxInterval = d }
+}
+
+// WithCPU sets EnableCPU.
+func WithCPU(enabled bool) Option {
+	return func(c *Collector) { c.EnableCPU = enabled }
+}
+
+// WithMem sets EnableMem. Disabling it disables EnableGC as well, the GC
+// statistics are read with the memory statistics.
+func WithMem(enabled bool) Option {
+	return func(c *Collector) {
+		c.EnableMem = enabled
+		if !enabled {
+			c.EnableGC = false
+		}
+	}
+}
+
+// WithGC sets EnableGC.
+func WithGC(enabled bool) Option {
+	return func(c *Collector) { c.EnableGC = enabled }
+}
+
+// WithRuntimeMetrics sets EnableRuntimeMetrics.
+func WithRuntimeMetrics(enabled bool) Option {
+	return func(c *Collector) { c.EnableRuntimeMetrics = enabled }
+}
+
+// WithProcess sets EnableProcess.
+func WithProcess(enabled bool) Option {
+	return func(c *Collector) { c.EnableProcess = enabled }
+}
+
+// WithContention sets EnableContention.
+func WithContention(enabled bool) Option {
+	return func(c *Collector) { c.EnableContention = enabled }
+}
+
+// WithGoroutineStates sets EnableGoroutineStates.
+func WithGoroutineStates(enabled bool) Option {
+	return func(c *Collector) { c.EnableGoroutineStates = enabled }
+}
+
+// WithFinalizers sets EnableFinalizers.
+func WithFinalizers(enabled bool) Option {
+	return func(c *Collector) { c.EnableFinalizers = enabled }
+}
+
+// WithSizeClasses sets EnableSizeClasses.
+func WithSizeClasses(enabled bool) Option {
+	return func(c *Collector) { c.EnableSizeClasses = enabled }
+}
+
+// WithBuildInfo sets EnableBuildInfo.
+func WithBuildInfo(enabled bool) Option {
+	return func(c *Collector) { c.EnableBuildInfo = enabled }
+}
+
+// WithStartEpoch sets EnableStartEpoch.
+func WithStartEpoch(enabled bool) Option {
+	return func(c *Collector) { c.EnableStartEpoch = enabled }
+}
+
+// WithDerived sets EnableDerived.
+func WithDerived(enabled bool) Option {
+	return func(c *Collector) { c.EnableDerived = enabled }
+}
+
+// WithMemSampleEvery sets MemSampleEvery.
+func WithMemSampleEvery(n int) Option {
+	return func(c *Collector) { c.MemSampleEvery = n }
+}
+
+// WithFieldFilter sets FieldFilter to a copy of keys.
+func WithFieldFilter(keys ...string) Option {
+	keys = append([]string(nil), keys...)
+	return func(c *Collector) { c.FieldFilter = keys }
+}
+
+// WithPausePercentiles sets PausePercentiles to a copy of percentiles.
+func WithPausePercentiles(percentiles ...float64) Option {
+	percentiles = append([]float64(nil), percentiles...)
+	return func(c *Collector) { c.PausePercentiles = percentiles }
+}
+
+// WithOnGOMAXPROCSChange sets OnGOMAXPROCSChange.
+func WithOnGOMAXPROCSChange(fn func(prev, cur int64)) Option {
+	return func(c *Collector) { c.OnGOMAXPROCSChange = fn }
+}
+
+// WithOnPauseExceeded sets OnPauseExceeded and PauseThreshold.
+func WithOnPauseExceeded(threshold time.Duration, fn func(pauseNs int64)) Option {
+	return func(c *Collector) {
+		c.PauseThreshold = threshold
+		c.OnPauseExceeded = fn
+	}
+}
+
+// WithHistorySize sets HistorySize.
+func WithHistorySize(n int) Option {
+	return func(c *Collector) { c.HistorySize = n }
+}
+
+// WithTags sets the tags like SetTags.
+func WithTags(tags map[string]string) Option {
+	return func(c *Collector) { c.SetTags(tags) }
+}
+
+// WithTrigger sets Trigger.
+func WithTrigger(trigger <-chan struct{}) Option {
+	return func(c *Collector) { c.Trigger = trigger }
+}
+
+// WithDone sets Done.
+func WithDone(done <-chan struct{}) Option {
+	return func(c *Collector) { c.Done = done }
+}
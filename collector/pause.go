@@ -0,0 +1,99 @@
+package collector
+
+import (
+	"math"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// collectPauseStats summarizes the GC pauses still held in the circular
+// MemStats.PauseNs buffer.
+func (c *Collector) collectPauseStats(fields *Fields, m *runtime.MemStats) {
+	n := int(m.NumGC)
+	if n > len(m.PauseNs) {
+		n = len(m.PauseNs)
+	}
+
+	// The percentile keys are always output so the set of keys is stable from
+	// the very first collection, before any GC has run.
+	if len(c.PausePercentiles) > 0 {
+		fields.PausePercentiles = make(map[string]int64, len(c.PausePercentiles))
+		for _, p := range c.PausePercentiles {
+			fields.PausePercentiles[pausePercentileKey(p)] = 0
+		}
+	}
+
+	if n == 0 {
+		return
+	}
+
+	pauses := make([]uint64, n)
+	copy(pauses, m.PauseNs[:n])
+	sort.Slice(pauses, func(i, j int) bool { return pauses[i] < pauses[j] })
+
+	fields.PauseMin = int64(pauses[0])
+	fields.PauseMax = int64(pauses[n-1])
+
+	for _, p := range c.PausePercentiles {
+		fields.PausePercentiles[pausePercentileKey(p)] = int64(pauses[percentileIndex(n, p)])
+	}
+}
+
+// percentileIndex returns the nearest-rank index of percentile p in a sorted
+// slice of length n.
+func percentileIndex(n int, p float64) int {
+	i := int(math.Ceil(p/100*float64(n))) - 1
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+func pausePercentileKey(p float64) string {
+	return "mem.gc.pause_p" + strings.Replace(strconv.FormatFloat(p, 'f', -1, 64), ".", "_", -1)
+}
+
+// pausePercentileKeys returns the keys of the configured PausePercentiles, all
+// with a zero value.
+func (c *Collector) pausePercentileKeys() map[string]int64 {
+	keys := make(map[string]int64, len(c.PausePercentiles))
+	for _, p := range c.PausePercentiles {
+		keys[pausePercentileKey(p)] = 0
+	}
+	return keys
+}
+
+// checkPauses calls OnPauseExceeded for the pauses above PauseThreshold of the
+// GCs since the previous check. The first check only records the number of GCs,
+// the pauses before it predate the collector.
+func (c *Collector) checkPauses(m *runtime.MemStats) {
+	c.mu.Lock()
+	from := c.checkedGC
+	if !c.seededGC {
+		from = m.NumGC
+		c.checkedGC, c.seededGC = m.NumGC, true
+	}
+	if m.NumGC > from {
+		c.checkedGC = m.NumGC
+	}
+	c.mu.Unlock()
+
+	if m.NumGC <= from {
+		return
+	}
+
+	if m.NumGC-from > uint32(len(m.PauseNs)) {
+		from = m.NumGC - uint32(len(m.PauseNs))
+	}
+
+	for gc := from + 1; gc <= m.NumGC; gc++ {
+		if pause := int64(m.PauseNs[(gc+255)%256]); pause > int64(c.PauseThreshold) {
+			c.OnPauseExceeded(pause)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package collector
+
+// procStats holds the process statistics read by the platform specific
+// readProcStats. Statistics unavailable on a platform are left at zero.
+type procStats struct {
+	RSS       int64
+	CPUUser   int64
+	CPUSystem int64
+	OpenFDs   int64
+}
@@ -0,0 +1,61 @@
+//go:build linux
+
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// clockTicks is the assumed value of sysconf(_SC_CLK_TCK), which is 100 on
+// virtually all Linux systems.
+const clockTicks = 100
+
+// readProcStats reads process statistics from /proc/self. Statistics that cannot
+// be read, for example when /proc is not mounted, are left at zero.
+func readProcStats(s *procStats) {
+	if f, err := os.Open("/proc/self/status"); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "VmRSS:") {
+				continue
+			}
+			// e.g. "VmRSS:	    4640 kB"
+			if fields := strings.Fields(line); len(fields) >= 2 {
+				if kb, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+					s.RSS = kb * 1024
+				}
+			}
+			break
+		}
+		f.Close()
+	}
+
+	if stat, err := os.ReadFile("/proc/self/stat"); err == nil {
+		// The command name in the second field may contain spaces, the remaining
+		// fields start after its closing parenthesis with the state (field 3).
+		if i := bytes.LastIndexByte(stat, ')'); i >= 0 {
+			fields := strings.Fields(string(stat[i+1:]))
+			if len(fields) > 12 {
+				s.CPUUser = ticksToNs(fields[11])
+				s.CPUSystem = ticksToNs(fields[12])
+			}
+		}
+	}
+
+	if fds, err := os.ReadDir("/proc/self/fd"); err == nil {
+		s.OpenFDs = int64(len(fds))
+	}
+}
+
+func ticksToNs(s string) int64 {
+	ticks, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return ticks * (1e9 / clockTicks)
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !windows && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package collector
+
+// readProcStats leaves all statistics at zero, process statistics are not
+// supported on this platform.
+func readProcStats(s *procStats) {}
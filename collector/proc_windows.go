@@ -0,0 +1,68 @@
+//go:build windows
+
+package collector
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modpsapi    = syscall.NewLazyDLL("psapi.dll")
+	modkernel32 = syscall.NewLazyDLL("kernel32.dll")
+
+	procGetProcessMemoryInfo  = modpsapi.NewProc("GetProcessMemoryInfo")
+	procGetProcessHandleCount = modkernel32.NewProc("GetProcessHandleCount")
+)
+
+// processMemoryCounters is the PROCESS_MEMORY_COUNTERS structure filled by
+// GetProcessMemoryInfo.
+type processMemoryCounters struct {
+	CB                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+// readProcStats reads the CPU times with GetProcessTimes, the working set size
+// as resident set size with GetProcessMemoryInfo and the number of open handles
+// as open file descriptors with GetProcessHandleCount. Statistics that cannot be
+// read are left at zero.
+func readProcStats(s *procStats) {
+	h, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return
+	}
+
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user); err == nil {
+		s.CPUUser = filetimeToNs(user)
+		s.CPUSystem = filetimeToNs(kernel)
+	}
+
+	if procGetProcessMemoryInfo.Find() == nil {
+		var mem processMemoryCounters
+		mem.CB = uint32(unsafe.Sizeof(mem))
+		if r, _, _ := procGetProcessMemoryInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&mem)), uintptr(mem.CB)); r != 0 {
+			s.RSS = int64(mem.WorkingSetSize)
+		}
+	}
+
+	if procGetProcessHandleCount.Find() == nil {
+		var handles uint32
+		if r, _, _ := procGetProcessHandleCount.Call(uintptr(h), uintptr(unsafe.Pointer(&handles))); r != 0 {
+			s.OpenFDs = int64(handles)
+		}
+	}
+}
+
+// filetimeToNs converts a duration reported in 100 nanosecond intervals.
+func filetimeToNs(ft syscall.Filetime) int64 {
+	return (int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)) * 100
+}
@@ -0,0 +1,66 @@
+package collector
+
+import (
+	"math"
+	"runtime/metrics"
+)
+
+// Metrics read when EnableRuntimeMetrics is set. Each requires a minimum Go
+// version, metrics unknown to the running version are reported as zero.
+const (
+	heapLiveMetric     = "/gc/heap/live:bytes"            // Go 1.21
+	schedLatencyMetric = "/sched/latencies:seconds"       // Go 1.17
+	mutexWaitMetric    = "/sync/mutex/wait/total:seconds" // Go 1.20
+)
+
+func (_ *Collector) collectRuntimeMetrics(fields *Fields) {
+	samples := []metrics.Sample{
+		{Name: heapLiveMetric},
+		{Name: schedLatencyMetric},
+		{Name: mutexWaitMetric},
+	}
+	metrics.Read(samples)
+
+	if v := samples[0].Value; v.Kind() == metrics.KindUint64 {
+		fields.HeapLive = int64(v.Uint64())
+	}
+
+	if v := samples[1].Value; v.Kind() == metrics.KindFloat64Histogram {
+		h := v.Float64Histogram()
+		fields.SchedLatencyP50 = secondsToNs(histogramQuantile(h, 0.50))
+		fields.SchedLatencyP99 = secondsToNs(histogramQuantile(h, 0.99))
+	}
+
+	if v := samples[2].Value; v.Kind() == metrics.KindFloat64 {
+		fields.MutexWaitNs = secondsToNs(v.Float64())
+	}
+}
+
+// histogramQuantile returns the upper bound of the bucket containing quantile q.
+// The lower bound is used for the last bucket as its upper bound is +Inf.
+func histogramQuantile(h *metrics.Float64Histogram, q float64) float64 {
+	var total uint64
+	for _, count := range h.Counts {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(total)))
+	var cumulative uint64
+	for i, count := range h.Counts {
+		cumulative += count
+		if cumulative >= rank {
+			if upper := h.Buckets[i+1]; !math.IsInf(upper, 1) {
+				return upper
+			}
+			return h.Buckets[i]
+		}
+	}
+	return h.Buckets[len(h.Buckets)-1]
+}
+
+func secondsToNs(s float64) int64 {
+	return int64(s * 1e9)
+}
@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"runtime"
+	"runtime/metrics"
+)
+
+const (
+	schedThreadsMetric           = "/sched/threads/total:threads"
+	schedGoroutinesWaitingMetric = "/sched/goroutines/waiting:goroutines"
+)
+
+// readSchedStats reads scheduler statistics from runtime/metrics. Metrics not
+// supported by the running Go version are left at zero, except for the thread
+// count which falls back to the number of threads created by the runtime.
+func readSchedStats(s *CPUStats) {
+	// Unlike /sched/gomaxprocs:threads this works on every Go version, passing 0
+	// only queries the setting.
+	s.GOMAXPROCS = int64(runtime.GOMAXPROCS(0))
+
+	created, _ := runtime.ThreadCreateProfile(nil)
+	s.NumOSThread = int64(created)
+
+	samples := [...]metrics.Sample{
+		{Name: schedThreadsMetric},
+		{Name: schedGoroutinesWaitingMetric},
+	}
+	metrics.Read(samples[:])
+
+	if v := samples[0].Value; v.Kind() == metrics.KindUint64 {
+		s.NumThread = int64(v.Uint64())
+	} else {
+		s.NumThread = s.NumOSThread
+	}
+
+	if v := samples[1].Value; v.Kind() == metrics.KindUint64 {
+		s.NumGoroutineWaiting = int64(v.Uint64())
+	}
+}
+
+// checkGOMAXPROCS calls OnGOMAXPROCSChange when n differs from the value of the
+// previous collection. The first collection only records n.
+func (c *Collector) checkGOMAXPROCS(n int64) {
+	c.mu.Lock()
+	prev := c.gomaxprocs
+	c.gomaxprocs = n
+	c.mu.Unlock()
+
+	if prev != 0 && prev != n {
+		c.OnGOMAXPROCSChange(prev, n)
+	}
+}
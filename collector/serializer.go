@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Serializer encodes a set of statistics, the tags describing them and the time
+// of their collection into a message of some format, e.g. a line of InfluxDB
+// line protocol. Implementations for other packages' formats are
+// influxdb.LineProtocol and prometheus.TextSerializer.
+type Serializer interface {
+	Serialize(fields Fields, tags map[string]string, t time.Time) ([]byte, error)
+}
+
+// SerializerFunc adapts an ordinary function to the Serializer interface.
+type SerializerFunc func(fields Fields, tags map[string]string, t time.Time) ([]byte, error)
+
+// Serialize calls f(fields, tags, t).
+func (f SerializerFunc) Serialize(fields Fields, tags map[string]string, t time.Time) ([]byte, error) {
+	return f(fields, tags, t)
+}
+
+// JSONSerializer encodes statistics as a newline terminated JSON object
+// containing the keys returned by Fields.Values, the tags and a "time" key
+// holding the RFC 3339 time.
+type JSONSerializer struct{}
+
+// Serialize implements Serializer.
+func (JSONSerializer) Serialize(fields Fields, tags map[string]string, t time.Time) ([]byte, error) {
+	values := fields.Values()
+	for k, v := range tags {
+		values[k] = v
+	}
+	values["time"] = t.Format(time.RFC3339Nano)
+
+	b, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// WriterSink writes every set of statistics to Writer, encoded by Serializer.
+type WriterSink struct {
+	Writer     io.Writer
+	Serializer Serializer
+
+	// OnError is called with errors of the Serializer and the Writer, which are
+	// ignored if it is nil.
+	OnError func(error)
+}
+
+// NewWriterSink returns a FieldsFunc which writes every set of statistics,
+// tagged with Fields.Tags, to w in the format of s. Errors are ignored.
+//
+//	package main
+//
+//	import (
+//	   "os"
+//	   "github.com/tevjef/go-runtime-metrics/collector"
+//	)
+//
+//	func main {
+//	    collector.New(collector.NewWriterSink(os.Stdout, collector.JSONSerializer{})).Run()
+//	}
+func NewWriterSink(w io.Writer, s Serializer) FieldsFunc {
+	return (&WriterSink{Writer: w, Serializer: s}).Send
+}
+
+// Send writes fields with the time of the call. It matches the signature of
+// FieldsFunc.
+func (s *WriterSink) Send(fields Fields) {
+	b, err := s.Serializer.Serialize(fields, fields.Tags(), time.Now())
+	if err == nil {
+		_, err = s.Writer.Write(b)
+	}
+
+	if err != nil && s.OnError != nil {
+		s.OnError(err)
+	}
+}
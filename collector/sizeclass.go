@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const sizeClassPrefix = "mem.bysize."
+
+// collectSizeClasses outputs the allocation and free counts of every size
+// class in MemStats.BySize, keyed by the largest object size of the class.
+func (_ *Collector) collectSizeClasses(fields *Fields, m *runtime.MemStats) {
+	fields.SizeClasses = make(map[string]int64, 2*len(m.BySize))
+	for _, class := range m.BySize {
+		// The first class holds no objects.
+		if class.Size == 0 {
+			continue
+		}
+
+		prefix := sizeClassPrefix + strconv.FormatUint(uint64(class.Size), 10)
+		fields.SizeClasses[prefix+".mallocs"] = int64(class.Mallocs)
+		fields.SizeClasses[prefix+".frees"] = int64(class.Frees)
+	}
+}
+
+// isSizeClassKey reports whether key has the form of a size class statistic,
+// mem.bysize.<size>.mallocs or mem.bysize.<size>.frees. The sizes depend on
+// the Go version, so they are not checked against the actual classes.
+func isSizeClassKey(key string) bool {
+	if !strings.HasPrefix(key, sizeClassPrefix) {
+		return false
+	}
+
+	size, suffix, ok := strings.Cut(key[len(sizeClassPrefix):], ".")
+	if !ok || (suffix != "mallocs" && suffix != "frees") {
+		return false
+	}
+
+	_, err := strconv.ParseUint(size, 10, 32)
+	return err == nil
+}
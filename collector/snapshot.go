@@ -0,0 +1,66 @@
+package collector
+
+import "reflect"
+
+// Snapshot gathers statistics like OneOff but reports the monotonic counters as
+// the difference since the previous call to Snapshot. The first call reports the
+// counters accumulated since the process started. Gauges are always absolute.
+//
+// The counters are the statistics whose FieldMeta.Kind is Counter, such as
+// mem.malloc, mem.gc.count and the mallocs and frees of the size classes, see
+// LookupFieldMeta.
+//
+// It is safe for use from multiple go routines, each call advances the baseline
+// of every caller.
+func (c *Collector) Snapshot() Fields {
+	fields := c.collectStats()
+	current := fields
+
+	c.mu.Lock()
+	if c.lastSnapshot != nil {
+		subtractCounters(&fields, c.lastSnapshot)
+	}
+	c.lastSnapshot = &current
+	c.mu.Unlock()
+
+	return fields
+}
+
+// Indexes of the members of Fields holding the statistics of kind Counter
+var counterFields = func() []int {
+	var indexes []int
+
+	t := reflect.TypeOf(Fields{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		meta, ok := LookupFieldMeta(field.Tag.Get("json"))
+
+		if ok && meta.Kind == Counter && field.Type.Kind() == reflect.Int64 {
+			indexes = append(indexes, i)
+		}
+	}
+
+	return indexes
+}()
+
+// subtractCounters subtracts the monotonic counters of prev from f.
+func subtractCounters(f, prev *Fields) {
+	fv, pv := reflect.ValueOf(f).Elem(), reflect.ValueOf(prev).Elem()
+
+	for _, i := range counterFields {
+		fv.Field(i).SetInt(fv.Field(i).Int() - pv.Field(i).Int())
+	}
+
+	// The map is shared with the baseline of the next call, so the deltas go
+	// into a copy.
+	if f.SizeClasses != nil {
+		sizeClasses := make(map[string]int64, len(f.SizeClasses))
+		for key, n := range f.SizeClasses {
+			if isSizeClassKey(key) {
+				n -= prev.SizeClasses[key]
+			}
+			sizeClasses[key] = n
+		}
+		f.SizeClasses = sizeClasses
+	}
+}
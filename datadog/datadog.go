@@ -0,0 +1,279 @@
+package datadog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+const (
+	defaultSite          = "datadoghq.com"
+	defaultPrefix        = "go."
+	defaultBatchInterval = 60 * time.Second
+	defaultTimeout       = 10 * time.Second
+)
+
+// Metric types of the v2 series API
+const (
+	typeCount = 1
+	typeGauge = 3
+)
+
+// Config configures the Client.
+type Config struct {
+	// API key used to authenticate with Datadog. Required.
+	APIKey string
+
+	// Datadog site the metrics are submitted to, e.g. "datadoghq.eu".
+	// Default is "datadoghq.com"
+	Site string
+
+	// Prefix prepended to every statistic key to form the metric name.
+	// Default is "go."
+	Prefix string
+
+	// Additional tags attached to every metric, e.g. "env:prod". The keys
+	// returned by Fields.Tags are always attached.
+	Tags []string
+
+	// Interval in which the collected statistics are submitted.
+	// Default is 60 seconds
+	BatchInterval time.Duration
+
+	// HTTP client used to submit the metrics.
+	// Default is a client with a 10 second timeout
+	HTTPClient *http.Client
+
+	// Called with errors that caused metrics to be dropped, such as failed
+	// submissions. Errors are ignored when nil.
+	OnError func(error)
+}
+
+// Client submits collected statistics to the Datadog v2 series API over HTTPS
+// in batches. Monotonic counters such as mem.gc.count are submitted as counts of
+// their increase, all other statistics as gauges.
+//
+//	package main
+//
+//	import (
+//	   "github.com/tevjef/go-runtime-metrics/collector"
+//	   "github.com/tevjef/go-runtime-metrics/datadog"
+//	)
+//
+//	func main {
+//	    client, err := datadog.New(datadog.Config{APIKey: os.Getenv("DD_API_KEY")})
+//	    if err != nil {
+//	        // handle error
+//	    }
+//	    defer client.Close()
+//	    go collector.New(client.Send).Run()
+//	}
+type Client struct {
+	config Config
+	url    string
+
+	mu     sync.Mutex
+	series map[string]*series
+
+	// Previous values of the counters and times of the collections, keyed like
+	// series by the metric and the sorted tags, as series with other tags count
+	// separately
+	previous   map[string]float64
+	previousAt map[string]int64
+
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+type payload struct {
+	Series []*series `json:"series"`
+}
+
+type series struct {
+	Metric   string   `json:"metric"`
+	Type     int      `json:"type"`
+	Interval int64    `json:"interval,omitempty"`
+	Points   []point  `json:"points"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+type point struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// New creates a Client and starts submitting every BatchInterval. Call Close to
+// submit the remaining statistics and stop.
+func New(config Config) (*Client, error) {
+	if config.APIKey == "" {
+		return nil, errors.New("datadog requires an api key")
+	}
+
+	if config.Site == "" {
+		config.Site = defaultSite
+	}
+
+	if config.Prefix == "" {
+		config.Prefix = defaultPrefix
+	}
+
+	if config.BatchInterval <= 0 {
+		config.BatchInterval = defaultBatchInterval
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: defaultTimeout}
+	}
+
+	c := &Client{
+		config:     config,
+		url:        "https://api." + config.Site + "/api/v2/series",
+		series:     map[string]*series{},
+		previous:   map[string]float64{},
+		previousAt: map[string]int64{},
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	go c.loop()
+
+	return c, nil
+}
+
+// Send adds every value of fields to the next submission. It matches the
+// signature of collector.FieldsFunc.
+func (c *Client) Send(fields collector.Fields) {
+	tags := make([]string, 0, len(c.config.Tags))
+	tags = append(tags, c.config.Tags...)
+	for k, v := range fields.Tags() {
+		tags = append(tags, k+":"+v)
+	}
+	sort.Strings(tags)
+	tagsKey := strings.Join(tags, ",")
+
+	now := time.Now().Unix()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Counts cover the time since the previous collection with these tags.
+	interval := now - c.previousAt[tagsKey]
+	c.previousAt[tagsKey] = now
+
+	for key, value := range fields.Values() {
+		var v float64
+		switch value := value.(type) {
+		case int64:
+			v = float64(value)
+		case float64:
+			v = value
+		default:
+			continue
+		}
+
+		// Monotonic counters are submitted as counts of the increase since the
+		// previous collection.
+		name := c.config.Prefix + key
+		seriesKey := name + "\x00" + tagsKey
+
+		metricType := typeGauge
+		if meta, _ := collector.LookupFieldMeta(key); meta.Kind == collector.Counter {
+			prev, ok := c.previous[seriesKey]
+			c.previous[seriesKey] = v
+			if !ok || v < prev {
+				continue
+			}
+
+			metricType = typeCount
+			v -= prev
+		}
+
+		s, ok := c.series[seriesKey]
+		if !ok {
+			s = &series{Metric: name, Type: metricType, Tags: tags}
+			if metricType == typeCount && interval > 0 {
+				s.Interval = interval
+			}
+			c.series[seriesKey] = s
+		}
+		s.Points = append(s.Points, point{Timestamp: now, Value: v})
+	}
+}
+
+// Flush submits the statistics added since the last submission.
+func (c *Client) Flush() error {
+	c.mu.Lock()
+	p := payload{Series: make([]*series, 0, len(c.series))}
+	for _, s := range c.series {
+		p.Series = append(p.Series, s)
+	}
+	c.series = map[string]*series{}
+	c.mu.Unlock()
+
+	if len(p.Series) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode series")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", c.config.APIKey)
+
+	resp, err := c.config.HTTPClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to submit series")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.Errorf("failed to submit series: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// Close submits the remaining statistics and stops the Client.
+func (c *Client) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+	})
+	<-c.stopped
+
+	return c.Flush()
+}
+
+func (c *Client) loop() {
+	defer close(c.stopped)
+
+	tick := time.NewTicker(c.config.BatchInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-tick.C:
+			if err := c.Flush(); err != nil && c.config.OnError != nil {
+				c.config.OnError(err)
+			}
+		}
+	}
+}
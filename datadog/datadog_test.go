@@ -0,0 +1,119 @@
+package datadog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+func TestFlush(t *testing.T) {
+	var received payload
+	var apiKey string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey = r.Header.Get("DD-API-KEY")
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	client, err := New(Config{APIKey: "key", Tags: []string{"env:test"}, BatchInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.url = server.URL
+
+	c := collector.New(nil)
+	client.Send(c.OneOff())
+	client.Send(c.OneOff())
+
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if apiKey != "key" {
+		t.Errorf("expected the api key header, got %q", apiKey)
+	}
+
+	types := map[string]int{}
+	for _, s := range received.Series {
+		types[s.Metric] = s.Type
+
+		if s.Metric == "go.cpu.goroutines" && len(s.Points) != 2 {
+			t.Errorf("expected both collections in one series, got %d points", len(s.Points))
+		}
+		if s.Metric == "go.mem.gc.count" && len(s.Points) != 1 {
+			t.Errorf("expected a single count after two collections, got %d points", len(s.Points))
+		}
+	}
+
+	if types["go.cpu.goroutines"] != typeGauge {
+		t.Errorf("expected go.cpu.goroutines to be a gauge, got type %d", types["go.cpu.goroutines"])
+	}
+	if types["go.mem.gc.count"] != typeCount {
+		t.Errorf("expected go.mem.gc.count to be a count, got type %d", types["go.mem.gc.count"])
+	}
+}
+
+func TestCountsPerTags(t *testing.T) {
+	client, err := New(Config{APIKey: "key", BatchInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(client.done)
+
+	send := func(shard string, cgoCalls int64) {
+		client.Send(collector.Fields{NumCgoCall: cgoCalls, ExtraTags: map[string]string{"shard": shard}})
+	}
+	send("a", 100)
+	send("b", 5)
+	send("a", 110)
+	send("b", 7)
+
+	counts := map[string]float64{}
+	for _, s := range client.series {
+		if s.Metric == "go.cpu.cgo_calls" {
+			for _, tag := range s.Tags {
+				if strings.HasPrefix(tag, "shard:") {
+					counts[tag] = s.Points[0].Value
+				}
+			}
+		}
+	}
+
+	if counts["shard:a"] != 10 || counts["shard:b"] != 2 {
+		t.Errorf("expected the counts of every shard, got %v", counts)
+	}
+}
+
+func TestFlushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid api key", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	client, err := New(Config{APIKey: "key", BatchInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.url = server.URL
+
+	client.Send(collector.New(nil).OneOff())
+
+	if err := client.Close(); err == nil {
+		t.Error("expected the rejected submission to fail")
+	}
+}
+
+func TestNewRequiresAPIKey(t *testing.T) {
+	if _, err := New(Config{}); err == nil {
+		t.Error("expected an error without an api key")
+	}
+}
@@ -0,0 +1,259 @@
+package runstats
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Prefix of the environment variables read by ConfigFromEnv
+const envPrefix = "RUNSTATS_"
+
+// ConfigFromEnv returns a Config populated from the RUNSTATS_* environment
+// variables. Unset and empty variables leave the defaults in place. Durations
+// are parsed with time.ParseDuration, e.g. "10s", and booleans with
+// strconv.ParseBool. Lists are comma separated and RUNSTATS_TAGS holds
+// comma separated key=value pairs. RUNSTATS_BACKPRESSURE is one of drop_newest,
+// drop_oldest or block, and setting any of the RUNSTATS_V2_* variables writes
+// to InfluxDB 2.x. The variables are
+//
+//	RUNSTATS_ADDR                          Host
+//	RUNSTATS_HOSTS                         Hosts
+//	RUNSTATS_DATABASE                      Database
+//	RUNSTATS_USERNAME                      Username
+//	RUNSTATS_PASSWORD                      Password
+//	RUNSTATS_MEASUREMENT                   Measurement
+//	RUNSTATS_RETENTION_POLICY              RetentionPolicy
+//	RUNSTATS_CREATE_RETENTION_POLICY       CreateRetentionPolicy
+//	RUNSTATS_RETENTION_POLICY_DURATION     RetentionPolicyDuration
+//	RUNSTATS_RETENTION_POLICY_REPLICATION  RetentionPolicyReplication
+//	RUNSTATS_WRITE_CONSISTENCY             WriteConsistency
+//	RUNSTATS_PRECISION                     Precision
+//	RUNSTATS_BYTE_UNIT                     ByteUnit
+//	RUNSTATS_HTTP_PROXY                    HTTPProxy
+//	RUNSTATS_SOCKET_PATH                   SocketPath
+//	RUNSTATS_TLS_CERT_FILE                 TLSCertFile
+//	RUNSTATS_TLS_KEY_FILE                  TLSKeyFile
+//	RUNSTATS_TLS_CA_FILE                   TLSCAFile
+//	RUNSTATS_FIELD_PREFIX                  FieldPrefix
+//	RUNSTATS_FIELD_FILTER                  FieldFilter
+//	RUNSTATS_TAGS                          Tags
+//	RUNSTATS_PROCESS_TAG                   ProcessTag
+//	RUNSTATS_DISABLE_PROCESS_TAG           DisableProcessTag
+//	RUNSTATS_EXPVAR_NAME                   ExpvarName
+//	RUNSTATS_V2_ORG                        V2.Org
+//	RUNSTATS_V2_BUCKET                     V2.Bucket
+//	RUNSTATS_V2_TOKEN                      V2.Token
+//	RUNSTATS_INTERVAL                      CollectionInterval
+//	RUNSTATS_BATCH_INTERVAL                BatchInterval
+//	RUNSTATS_INTERVAL_JITTER               IntervalJitter
+//	RUNSTATS_WRITE_TIMEOUT                 WriteTimeout
+//	RUNSTATS_STARTUP_RETRIES               StartupRetries
+//	RUNSTATS_STARTUP_RETRY_INTERVAL        StartupRetryInterval
+//	RUNSTATS_GOROUTINES_MAX_INTERVAL       GoroutinesMaxInterval
+//	RUNSTATS_MAX_BATCH_POINTS              MaxBatchPoints
+//	RUNSTATS_POINT_BUFFER_SIZE             PointBufferSize
+//	RUNSTATS_BACKPRESSURE                  Backpressure
+//	RUNSTATS_PAYLOAD_SIZE                  PayloadSize
+//	RUNSTATS_FLOAT_DECIMALS                FloatDecimals
+//	RUNSTATS_USE_UDP                       UseUDP
+//	RUNSTATS_GZIP                          GzipEnabled
+//	RUNSTATS_DRY_RUN                       DryRun
+//	RUNSTATS_MONOTONIC_TIMESTAMPS          MonotonicTimestamps
+//	RUNSTATS_SKIP_DATABASE_CREATION        SkipDatabaseCreation
+//	RUNSTATS_DISABLE_CPU                   DisableCpu
+//	RUNSTATS_DISABLE_MEM                   DisableMem
+//	RUNSTATS_DISABLE_GC                    DisableGc
+//	RUNSTATS_ENABLE_RUNTIME_METRICS        EnableRuntimeMetrics
+//	RUNSTATS_ENABLE_PROCESS                EnableProcess
+//	RUNSTATS_ENABLE_DERIVED                EnableDerived
+//	RUNSTATS_ENABLE_CONTENTION             EnableContention
+//	RUNSTATS_ENABLE_GOROUTINE_STATES       EnableGoroutineStates
+//	RUNSTATS_ENABLE_FINALIZERS             EnableFinalizers
+//	RUNSTATS_ENABLE_SIZE_CLASSES           EnableSizeClasses
+//	RUNSTATS_ENABLE_BUILD_INFO             EnableBuildInfo
+//	RUNSTATS_ENABLE_START_EPOCH            EnableStartEpoch
+//
+// The settings which cannot be expressed as a string are left out: TLSConfig,
+// MeasurementVars, FieldKeyFunc, FieldsInterceptor, Clock, Logger, OnError and
+// OnFlush. The returned Config is validated when passed to RunCollector.
+func ConfigFromEnv() (*Config, error) {
+	config := &Config{}
+
+	strs := map[string]*string{
+		"ADDR":              &config.Host,
+		"DATABASE":          &config.Database,
+		"USERNAME":          &config.Username,
+		"PASSWORD":          &config.Password,
+		"MEASUREMENT":       &config.Measurement,
+		"RETENTION_POLICY":  &config.RetentionPolicy,
+		"WRITE_CONSISTENCY": &config.WriteConsistency,
+		"PRECISION":         &config.Precision,
+		"BYTE_UNIT":         &config.ByteUnit,
+		"HTTP_PROXY":        &config.HTTPProxy,
+		"SOCKET_PATH":       &config.SocketPath,
+		"TLS_CERT_FILE":     &config.TLSCertFile,
+		"TLS_KEY_FILE":      &config.TLSKeyFile,
+		"TLS_CA_FILE":       &config.TLSCAFile,
+		"FIELD_PREFIX":      &config.FieldPrefix,
+		"PROCESS_TAG":       &config.ProcessTag,
+		"EXPVAR_NAME":       &config.ExpvarName,
+	}
+
+	v2 := &V2Config{}
+	v2Strs := map[string]*string{
+		"V2_ORG":    &v2.Org,
+		"V2_BUCKET": &v2.Bucket,
+		"V2_TOKEN":  &v2.Token,
+	}
+
+	lists := map[string]*[]string{
+		"HOSTS":        &config.Hosts,
+		"FIELD_FILTER": &config.FieldFilter,
+	}
+
+	durations := map[string]*time.Duration{
+		"INTERVAL":                  &config.CollectionInterval,
+		"BATCH_INTERVAL":            &config.BatchInterval,
+		"INTERVAL_JITTER":           &config.IntervalJitter,
+		"WRITE_TIMEOUT":             &config.WriteTimeout,
+		"STARTUP_RETRY_INTERVAL":    &config.StartupRetryInterval,
+		"RETENTION_POLICY_DURATION": &config.RetentionPolicyDuration,
+		"GOROUTINES_MAX_INTERVAL":   &config.GoroutinesMaxInterval,
+	}
+
+	ints := map[string]*int{
+		"STARTUP_RETRIES":              &config.StartupRetries,
+		"MAX_BATCH_POINTS":             &config.MaxBatchPoints,
+		"PAYLOAD_SIZE":                 &config.PayloadSize,
+		"FLOAT_DECIMALS":               &config.FloatDecimals,
+		"RETENTION_POLICY_REPLICATION": &config.RetentionPolicyReplication,
+		"POINT_BUFFER_SIZE":            &config.PointBufferSize,
+	}
+
+	bools := map[string]*bool{
+		"USE_UDP":                 &config.UseUDP,
+		"GZIP":                    &config.GzipEnabled,
+		"DRY_RUN":                 &config.DryRun,
+		"MONOTONIC_TIMESTAMPS":    &config.MonotonicTimestamps,
+		"DISABLE_PROCESS_TAG":     &config.DisableProcessTag,
+		"SKIP_DATABASE_CREATION":  &config.SkipDatabaseCreation,
+		"CREATE_RETENTION_POLICY": &config.CreateRetentionPolicy,
+		"DISABLE_CPU":             &config.DisableCpu,
+		"DISABLE_MEM":             &config.DisableMem,
+		"DISABLE_GC":              &config.DisableGc,
+		"ENABLE_RUNTIME_METRICS":  &config.EnableRuntimeMetrics,
+		"ENABLE_PROCESS":          &config.EnableProcess,
+		"ENABLE_DERIVED":          &config.EnableDerived,
+		"ENABLE_CONTENTION":       &config.EnableContention,
+		"ENABLE_GOROUTINE_STATES": &config.EnableGoroutineStates,
+		"ENABLE_FINALIZERS":       &config.EnableFinalizers,
+		"ENABLE_SIZE_CLASSES":     &config.EnableSizeClasses,
+		"ENABLE_BUILD_INFO":       &config.EnableBuildInfo,
+		"ENABLE_START_EPOCH":      &config.EnableStartEpoch,
+	}
+
+	for name, dst := range strs {
+		if v := os.Getenv(envPrefix + name); v != "" {
+			*dst = v
+		}
+	}
+
+	for name, dst := range v2Strs {
+		if v := os.Getenv(envPrefix + name); v != "" {
+			*dst = v
+			config.V2 = v2
+		}
+	}
+
+	for name, dst := range lists {
+		if v := os.Getenv(envPrefix + name); v != "" {
+			*dst = splitList(v)
+		}
+	}
+
+	for name, dst := range durations {
+		if v := os.Getenv(envPrefix + name); v != "" {
+			d, err := time.ParseDuration(v)
+
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid %s%s", envPrefix, name)
+			}
+
+			*dst = d
+		}
+	}
+
+	for name, dst := range ints {
+		if v := os.Getenv(envPrefix + name); v != "" {
+			n, err := strconv.Atoi(v)
+
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid %s%s", envPrefix, name)
+			}
+
+			*dst = n
+		}
+	}
+
+	for name, dst := range bools {
+		if v := os.Getenv(envPrefix + name); v != "" {
+			b, err := strconv.ParseBool(v)
+
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid %s%s", envPrefix, name)
+			}
+
+			*dst = b
+		}
+	}
+
+	if v := os.Getenv(envPrefix + "BACKPRESSURE"); v != "" {
+		policy, ok := backpressurePolicies[v]
+
+		if !ok {
+			return nil, errors.Errorf("invalid %sBACKPRESSURE %q, must be one of drop_newest, drop_oldest or block", envPrefix, v)
+		}
+
+		config.Backpressure = policy
+	}
+
+	if v := os.Getenv(envPrefix + "TAGS"); v != "" {
+		config.Tags = make(map[string]string)
+
+		for _, pair := range splitList(v) {
+			key, value, ok := strings.Cut(pair, "=")
+
+			if !ok || key == "" {
+				return nil, errors.Errorf("invalid %sTAGS: %q is not a key=value pair", envPrefix, pair)
+			}
+
+			config.Tags[key] = value
+		}
+	}
+
+	return config, nil
+}
+
+// Values of RUNSTATS_BACKPRESSURE
+var backpressurePolicies = map[string]BackpressurePolicy{
+	"drop_newest": DropNewest,
+	"drop_oldest": DropOldest,
+	"block":       Block,
+}
+
+// Split a comma separated list, dropping empty elements
+func splitList(s string) []string {
+	var list []string
+
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,76 @@
+package runstats
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Classes of the errors returned by RunCollector and Runner.Stop and passed to
+// Config.OnError. Use errors.Is to branch on them. ErrPingFailed and
+// ErrWriteFailed are usually transient and worth retrying, while ErrAuth
+// requires the credentials to be fixed.
+var (
+	// InfluxDB could not be reached during startup.
+	ErrPingFailed = errors.New("influxdb is not reachable")
+
+	// InfluxDB rejected the credentials.
+	ErrAuth = errors.New("influxdb rejected the credentials")
+
+	// Points could not be written to InfluxDB.
+	ErrWriteFailed = errors.New("could not write points to influxdb")
+)
+
+// ConfigError is returned by RunCollector for an invalid Config. It lists every
+// problem found rather than only the first one.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid config: " + strings.Join(e.Problems, "; ")
+}
+
+// classifiedError attaches one of the error classes to an error without
+// changing its message.
+type classifiedError struct {
+	class error
+	err   error
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+
+func (e *classifiedError) Unwrap() error { return e.err }
+
+func (e *classifiedError) Cause() error { return e.err }
+
+func (e *classifiedError) Is(target error) bool { return target == e.class }
+
+// Attach class to err, or ErrAuth if err was caused by rejected credentials.
+// err is returned unchanged when it is nil or when class is nil and err is not
+// an authentication error.
+func classify(class, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if isAuthError(err) {
+		class = ErrAuth
+	}
+
+	if class == nil {
+		return err
+	}
+
+	return &classifiedError{class: class, err: err}
+}
+
+// InfluxDB 1.x reports rejected credentials as "authorization failed" and 2.x
+// as "unauthorized", neither client exposes the status code.
+func isAuthError(err error) bool {
+	msg := strings.ToLower(err.Error())
+
+	return strings.Contains(msg, "authorization failed") ||
+		strings.Contains(msg, "unauthorized") ||
+		strings.Contains(msg, "unable to parse authentication credentials")
+}
@@ -0,0 +1,11 @@
+// Package auto publishes the InfluxDB formatted expvar variable with the default
+// name and measurement when imported.
+//
+//	import _ "github.com/tevjef/go-runtime-metrics/expvar/auto"
+package auto
+
+import "github.com/tevjef/go-runtime-metrics/expvar"
+
+func init() {
+	expvar.Publish("", "")
+}
@@ -7,8 +7,28 @@ import (
 	"github.com/tevjef/go-runtime-metrics/influxdb"
 )
 
-const defaultMeasurement = "go_runtime_metrics"
+// Publish exports an InfluxDB formatted variable with the given measurement name
+// under name, e.g. in /debug/vars. An empty name defaults to os.Args[0] and an
+// empty measurement to influxdb.DefaultMeasurement. Like expvar.Publish, it
+// panics if name is already registered.
+//
+//	package main
+//
+//	import (
+//	   "github.com/tevjef/go-runtime-metrics/expvar"
+//	)
+//
+//	func main {
+//	    expvar.Publish("runtime", "my-measurement-name")
+//	}
+func Publish(name, measurement string) {
+	if name == "" {
+		name = os.Args[0]
+	}
 
-func init() {
-	expvar.Publish(os.Args[0], influxdb.Metrics(defaultMeasurement))
+	if measurement == "" {
+		measurement = influxdb.DefaultMeasurement
+	}
+
+	expvar.Publish(name, influxdb.NewVar(measurement))
 }
@@ -0,0 +1,183 @@
+package graphite
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+const (
+	defaultDialTimeout  = 5 * time.Second
+	defaultWriteTimeout = 5 * time.Second
+)
+
+// Client sends collected statistics to Carbon using the plaintext protocol over
+// TCP, one "<prefix>.<key> <value> <timestamp>" line per statistic.
+//
+//	package main
+//
+//	import (
+//	   "github.com/tevjef/go-runtime-metrics/collector"
+//	   "github.com/tevjef/go-runtime-metrics/graphite"
+//	)
+//
+//	func main {
+//	    client, err := graphite.New("localhost:2003", "myapp.go")
+//	    if err != nil {
+//	        // handle error
+//	    }
+//	    go collector.New(client.Send).Run()
+//	}
+type Client struct {
+	// ReconnectAttempts is the number of times a new connection is dialed after a
+	// failed write before the statistics are dropped. Defaults to 1.
+	ReconnectAttempts int
+
+	// DialTimeout limits the time spent dialing Carbon. Defaults to 5 seconds.
+	DialTimeout time.Duration
+
+	// WriteTimeout limits the time spent writing the statistics, so that a
+	// stalled Carbon does not block collection. The connection is closed and
+	// dialed again after a timeout. Defaults to 5 seconds, no limit if 0.
+	WriteTimeout time.Duration
+
+	// OnError is called with errors that caused statistics to be dropped.
+	OnError func(error)
+
+	addr   string
+	prefix string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// New creates a Client connected to the Carbon plaintext port at addr. When
+// prefix is not empty it is joined with a dot to every statistic key.
+func New(addr, prefix string) (*Client, error) {
+	c := &Client{
+		ReconnectAttempts: 1,
+		DialTimeout:       defaultDialTimeout,
+		WriteTimeout:      defaultWriteTimeout,
+		addr:              addr,
+		prefix:            prefix,
+	}
+
+	if prefix != "" {
+		c.prefix += "."
+	}
+
+	if err := c.dial(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Send writes every value of fields. It matches the signature of
+// collector.FieldsFunc.
+func (c *Client) Send(fields collector.Fields) {
+	payload := c.format(fields.Values(), time.Now())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.write(payload)
+	for i := 0; err != nil && i < c.ReconnectAttempts; i++ {
+		if err = c.dial(); err == nil {
+			err = c.write(payload)
+		}
+	}
+
+	if err != nil && c.OnError != nil {
+		c.OnError(err)
+	}
+}
+
+// Close closes the underlying connection.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func (c *Client) dial() error {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	conn, err := net.DialTimeout("tcp", c.addr, c.DialTimeout)
+	if err != nil {
+		return err
+	}
+
+	c.conn = conn
+	return nil
+}
+
+func (c *Client) write(payload []byte) error {
+	if c.conn == nil {
+		return net.ErrClosed
+	}
+
+	if c.WriteTimeout > 0 {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(c.WriteTimeout)); err != nil {
+			return err
+		}
+	}
+
+	// A failed write may have sent part of a line, drop the connection so that
+	// the next write starts on a new one.
+	if _, err := c.conn.Write(payload); err != nil {
+		c.conn.Close()
+		c.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) format(values map[string]interface{}, t time.Time) []byte {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ts := strconv.FormatInt(t.Unix(), 10)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		var value string
+		switch v := values[key].(type) {
+		case int64:
+			value = strconv.FormatInt(v, 10)
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			continue
+		}
+
+		buf.WriteString(c.prefix)
+		buf.WriteString(key)
+		buf.WriteByte(' ')
+		buf.WriteString(value)
+		buf.WriteByte(' ')
+		buf.WriteString(ts)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes()
+}
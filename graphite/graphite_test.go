@@ -0,0 +1,138 @@
+package graphite
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+func TestSend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	lines := make(chan string, 1024)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+
+	client, err := New(l.Addr().String(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Send(collector.New(nil).OneOff())
+
+	found := false
+	timeout := time.After(time.Second)
+	for !found {
+		select {
+		case line := <-lines:
+			if !strings.HasPrefix(line, "test.cpu.goroutines ") {
+				continue
+			}
+			if parts := strings.Split(line, " "); len(parts) != 3 {
+				t.Errorf("unexpected line format: %s", line)
+			}
+			found = true
+		case <-timeout:
+			t.Fatal("expected key (test.cpu.goroutines) not received")
+		}
+	}
+}
+
+func TestSendReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := New(l.Addr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var errs []error
+	client.OnError = func(err error) { errs = append(errs, err) }
+
+	// Simulate a dropped connection, the next send must dial again.
+	client.conn.Close()
+	client.Send(collector.New(nil).OneOff())
+
+	if len(errs) != 0 {
+		t.Errorf("expected send to reconnect, got errors: %v", errs)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Accept the connections without ever reading from them
+	accepted := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	client, err := New(l.Addr().String(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	defer (<-accepted).Close()
+
+	client.WriteTimeout = 50 * time.Millisecond
+	client.mu.Lock()
+	err = client.write(make([]byte, 64<<20))
+	client.mu.Unlock()
+
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if client.conn != nil {
+		t.Error("expected the stalled connection to be dropped")
+	}
+
+	var errs []error
+	client.OnError = func(err error) { errs = append(errs, err) }
+	client.Send(collector.Fields{NumGoroutine: 1})
+
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("expected a new connection to be dialed")
+	}
+	if len(errs) != 0 {
+		t.Errorf("expected the write on the new connection to succeed, got %v", errs)
+	}
+}
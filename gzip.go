@@ -0,0 +1,134 @@
+package runstats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/pkg/errors"
+)
+
+// Writes batches to InfluxDB 1.x with gzip compressed requests. The HTTP client
+// of influxdb/client/v2 cannot compress the writes, it is still used to ping
+// and query.
+type gzipClient struct {
+	client.Client
+
+	url      string
+	username string
+	password string
+	http     *http.Client
+}
+
+// Wrap clnt to write to the InfluxDB 1.x server at addr with gzip
+func newGzipClient(clnt client.Client, addr string, config *Config, tlsConfig *tls.Config, proxy func(*http.Request) (*url.URL, error)) *gzipClient {
+	return &gzipClient{
+		Client:   clnt,
+		url:      strings.TrimSuffix(addr, "/") + "/write",
+		username: config.Username,
+		password: config.Password,
+		http: &http.Client{
+			Transport: &gzipTransport{
+				base: &http.Transport{Proxy: proxy, TLSClientConfig: tlsConfig},
+			},
+		},
+	}
+}
+
+func (c *gzipClient) Write(bp client.BatchPoints) error {
+	return c.WriteContext(context.Background(), bp)
+}
+
+// WriteContext writes bp to the /write endpoint, like client.Client does.
+func (c *gzipClient) WriteContext(ctx context.Context, bp client.BatchPoints) error {
+	var body bytes.Buffer
+	for _, pt := range bp.Points() {
+		body.WriteString(pt.PrecisionString(bp.Precision()))
+		body.WriteByte('\n')
+	}
+
+	params := url.Values{}
+	params.Set("db", bp.Database())
+	for key, value := range map[string]string{
+		"rp":          bp.RetentionPolicy(),
+		"precision":   bp.Precision(),
+		"consistency": bp.WriteConsistency(),
+	} {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"?"+params.Encode(), &body)
+
+	if err != nil {
+		return err
+	}
+
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	msg, _ := io.ReadAll(resp.Body)
+
+	var response struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(msg, &response) == nil && response.Error != "" {
+		return errors.New(response.Error)
+	}
+
+	return errors.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))
+}
+
+// Compresses the body of every request with gzip
+type gzipTransport struct {
+	base http.RoundTripper
+}
+
+func (t *gzipTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	_, err := io.Copy(zw, req.Body)
+	req.Body.Close()
+
+	if err == nil {
+		err = zw.Close()
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "could not compress request")
+	}
+
+	// A RoundTripper must not modify the request
+	compressed := req.Clone(req.Context())
+	compressed.Body = io.NopCloser(&body)
+	compressed.ContentLength = int64(body.Len())
+	compressed.Header.Set("Content-Encoding", "gzip")
+
+	return t.base.RoundTrip(compressed)
+}
@@ -0,0 +1,63 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+// DefaultMeasurement is the measurement name used when none is configured.
+const DefaultMeasurement = "go_runtime_metrics"
+
+// HTTPHandler is a http.Handler which responds to every request with freshly
+// collected statistics encoded as a JSON Point.
+type HTTPHandler struct {
+	// Measurement is the name of the Point. Defaults to DefaultMeasurement.
+	Measurement string
+
+	// Pretty indents the JSON output.
+	Pretty bool
+}
+
+// Handler returns a HTTPHandler with the default measurement name.
+//
+//	package main
+//
+//	import (
+//	   "net/http"
+//	   "github.com/tevjef/go-runtime-metrics/influxdb"
+//	)
+//
+//	func main {
+//	    http.Handle("/debug/runtime", influxdb.Handler())
+//	    http.ListenAndServe(":6060", nil)
+//	}
+func Handler() http.Handler {
+	return &HTTPHandler{Measurement: DefaultMeasurement}
+}
+
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	measurement := h.Measurement
+	if measurement == "" {
+		measurement = DefaultMeasurement
+	}
+
+	values := collector.New(nil).OneOff()
+	point := &Point{
+		Name:   measurement,
+		Tags:   values.Tags(),
+		Values: values,
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	enc := json.NewEncoder(w)
+	if h.Pretty {
+		enc.SetIndent("", "  ")
+	}
+
+	if err := enc.Encode(point); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
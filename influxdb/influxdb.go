@@ -15,7 +15,8 @@ type Point struct {
 
 // Metrics returns a expvar.Func which implements Var by calling the function
 // and formatting the returned value using JSON. Use this function when you need
-// control of the measurement name for a data point.
+// control of the measurement name for a data point. Use NewVar to reuse the
+// encoding buffer between calls.
 //
 //  package main
 //
@@ -30,13 +31,8 @@ type Point struct {
 //
 //
 func Metrics(measurement string) expvar.Func {
-	c := collector.New(nil)
+	v := NewVar(measurement)
 	return expvar.Func(func() interface{} {
-		values := c.OneOff()
-		return &Point{
-			Name:   measurement,
-			Tags:   values.Tags(),
-			Values: values,
-		}
+		return v.Collect()
 	})
 }
@@ -1,10 +1,18 @@
 package influxdb
 
 import (
+	"bytes"
 	"encoding/json"
 	"expvar"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
 )
 
 func TestMetrics(t *testing.T) {
@@ -30,6 +38,127 @@ func TestMetrics(t *testing.T) {
 	}
 }
 
+func TestVar(t *testing.T) {
+	v := NewVar("test")
+
+	for i := 0; i < 2; i++ {
+		point := &Point{}
+		if err := json.Unmarshal([]byte(v.String()), &point); err != nil {
+			t.Fatalf("invalid json: %v", err)
+		}
+
+		if point.Name != "test" || point.Values.NumGoroutine <= 0 {
+			t.Errorf("unexpected point: %+v", point)
+		}
+
+		v.Reset()
+	}
+
+	if point := v.Collect(); point.Name != "test" || point.Tags["go.version"] == "" {
+		t.Errorf("unexpected point: %+v", point)
+	}
+}
+
+func TestLatestVar(t *testing.T) {
+	v := NewLatestVar("test")
+	if s := v.String(); s != "null" {
+		t.Errorf("expected null before the first Set, got %s", s)
+	}
+
+	v.Set(collector.Fields{NumGoroutine: 42, Version: "go1"})
+
+	point := &Point{}
+	if err := json.Unmarshal([]byte(v.String()), &point); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+
+	if point.Name != "test" || point.Values.NumGoroutine != 42 || point.Tags["go.version"] != "go1" {
+		t.Errorf("unexpected point: %+v", point)
+	}
+}
+
+func TestMetricsPausePercentiles(t *testing.T) {
+	runtime.GC()
+
+	point := struct {
+		Values map[string]interface{} `json:"values"`
+	}{}
+	json.Unmarshal([]byte(Metrics("test").String()), &point)
+
+	if _, ok := point.Values["mem.gc.pause_p99"]; !ok {
+		t.Errorf("expected key (mem.gc.pause_p99) not found")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	for _, h := range []http.Handler{Handler(), &HTTPHandler{Measurement: "test", Pretty: true}} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("unexpected content type (%s)", ct)
+		}
+
+		point := struct {
+			Name   string                 `json:"name"`
+			Tags   map[string]string      `json:"tags"`
+			Values map[string]interface{} `json:"values"`
+		}{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &point); err != nil {
+			t.Fatalf("invalid json response: %v", err)
+		}
+
+		if point.Name == "" || point.Tags["go.version"] == "" {
+			t.Errorf("expected name and go.version tag, got (%s) (%v)", point.Name, point.Tags)
+		}
+		if _, ok := point.Values["cpu.goroutines"]; !ok {
+			t.Errorf("expected key (cpu.goroutines) not found")
+		}
+	}
+}
+
+func TestAppendLine(t *testing.T) {
+	tags := map[string]string{"go.os": "linux", "host name": "a,b", "empty": ""}
+	values := map[string]interface{}{
+		"mem.alloc":           int64(42),
+		"mem.gc.cpu_fraction": float64(0.5),
+		"note":                `say "hi"`,
+	}
+
+	line := string(AppendLine(nil, "go runtime,x", tags, values, time.Unix(0, 1234)))
+	exp := `go\ runtime\,x,go.os=linux,host\ name=a\,b mem.alloc=42i,mem.gc.cpu_fraction=0.5,note="say \"hi\"" 1234` + "\n"
+
+	if line != exp {
+		t.Errorf("unexpected line protocol:\ngot: %s\nexp: %s", line, exp)
+	}
+
+	line = string(AppendLine(nil, "test", nil, map[string]interface{}{"a": math.NaN(), "b": math.Inf(1), "c": 1.5}, time.Unix(0, 1)))
+	if line != "test c=1.5 1\n" {
+		t.Errorf("expected the non-finite values to be skipped, got %q", line)
+	}
+
+	buf := AppendLine([]byte("prev\n"), "test", nil, map[string]interface{}{"a": math.NaN(), "b": math.Inf(-1)}, time.Unix(0, 1))
+	if string(buf) != "prev\n" {
+		t.Errorf("expected no line without fields, got %q", buf)
+	}
+}
+
+func TestLineProtocolWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	NewLineProtocolWriter(buf, "test")(collector.New(nil).OneOff())
+
+	line := buf.String()
+	if !strings.HasPrefix(line, "test,go.arch=") || !strings.HasSuffix(line, "\n") {
+		t.Errorf("unexpected line protocol: %s", line)
+	}
+
+	for _, expKey := range []string{"cpu.goroutines=", "mem.lookups=", "mem.gc.count="} {
+		if !strings.Contains(line, expKey) {
+			t.Errorf("expected key (%s) not found", expKey)
+		}
+	}
+}
+
 func BenchmarkMetrics(b *testing.B) {
 	b.ReportAllocs()
 	b.RunParallel(func(pb *testing.PB) {
@@ -39,6 +168,17 @@ func BenchmarkMetrics(b *testing.B) {
 	})
 }
 
+func BenchmarkVar(b *testing.B) {
+	v := NewVar("some_metric")
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = v.String()
+		}
+	})
+}
+
 func BenchmarkMemstat(b *testing.B) {
 	b.ReportAllocs()
 	b.RunParallel(func(pb *testing.PB) {
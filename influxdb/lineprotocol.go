@@ -0,0 +1,121 @@
+package influxdb
+
+import (
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	keyEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
+	stringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// NewLineProtocolWriter returns a collector.FieldsFunc which writes every set of
+// statistics to w as a single line of InfluxDB line protocol with a nanosecond
+// timestamp. Errors returned by w are ignored.
+//
+//	package main
+//
+//	import (
+//	   "os"
+//	   "github.com/tevjef/go-runtime-metrics/collector"
+//	   "github.com/tevjef/go-runtime-metrics/influxdb"
+//	)
+//
+//	func main {
+//	    collector.New(influxdb.NewLineProtocolWriter(os.Stdout, "go.runtime")).Run()
+//	}
+func NewLineProtocolWriter(w io.Writer, measurement string) collector.FieldsFunc {
+	return collector.NewWriterSink(w, LineProtocol{Measurement: measurement})
+}
+
+// LineProtocol is a collector.Serializer encoding statistics as a single line
+// of InfluxDB line protocol, see AppendLine.
+type LineProtocol struct {
+	Measurement string
+}
+
+// Serialize implements collector.Serializer.
+func (l LineProtocol) Serialize(fields collector.Fields, tags map[string]string, t time.Time) ([]byte, error) {
+	return AppendLine(nil, l.Measurement, tags, fields.Values(), t), nil
+}
+
+// AppendLine appends a newline terminated InfluxDB line protocol representation
+// of the point to buf and returns the extended buffer. Tags and fields are
+// sorted by key. Values of an unsupported type and NaN or infinite floats, which
+// InfluxDB rejects, are skipped. buf is returned unchanged when no value is
+// left, as a line without fields is invalid.
+func AppendLine(buf []byte, measurement string, tags map[string]string, values map[string]interface{}, t time.Time) []byte {
+	line := len(buf)
+	buf = append(buf, measurementEscaper.Replace(measurement)...)
+
+	for _, key := range sortedKeys(tags) {
+		if tags[key] == "" {
+			continue
+		}
+		buf = append(buf, ',')
+		buf = append(buf, keyEscaper.Replace(key)...)
+		buf = append(buf, '=')
+		buf = append(buf, keyEscaper.Replace(tags[key])...)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sep := byte(' ')
+	for _, key := range keys {
+		start := len(buf)
+		buf = append(buf, sep)
+		buf = append(buf, keyEscaper.Replace(key)...)
+		buf = append(buf, '=')
+
+		switch v := values[key].(type) {
+		case int64:
+			buf = strconv.AppendInt(buf, v, 10)
+			buf = append(buf, 'i')
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				buf = buf[:start]
+				continue
+			}
+			buf = strconv.AppendFloat(buf, v, 'f', -1, 64)
+		case bool:
+			buf = strconv.AppendBool(buf, v)
+		case string:
+			buf = append(buf, '"')
+			buf = append(buf, stringEscaper.Replace(v)...)
+			buf = append(buf, '"')
+		default:
+			buf = buf[:start]
+			continue
+		}
+		sep = ','
+	}
+
+	if sep == ' ' {
+		return buf[:line]
+	}
+
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, t.UnixNano(), 10)
+	return append(buf, '\n')
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
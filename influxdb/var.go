@@ -0,0 +1,221 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+// Var is an expvar.Var which formats freshly collected statistics as a JSON
+// Point on every call of String. Unlike Metrics it reuses its buffers between
+// calls and encodes the values without reflection, and allows resetting the
+// collector state.
+//
+//	package main
+//
+//	import (
+//	   "expvar"
+//	   "github.com/tevjef/go-runtime-metrics/influxdb"
+//	)
+//
+//	func main {
+//	    expvar.Publish(os.Args[0], influxdb.NewVar("my-measurement-name"))
+//	}
+type Var struct {
+	measurement string
+
+	mu        sync.Mutex
+	collector *collector.Collector
+	enc       pointEncoder
+}
+
+// NewVar creates a Var with the given measurement name and a collector with the
+// default settings.
+func NewVar(measurement string) *Var {
+	return &Var{
+		measurement: measurement,
+		collector:   collector.New(nil),
+	}
+}
+
+// Collect gathers fresh statistics.
+func (v *Var) Collect() *Point {
+	v.mu.Lock()
+	c := v.collector
+	v.mu.Unlock()
+
+	values := c.OneOff()
+	return &Point{
+		Name:   v.measurement,
+		Tags:   values.Tags(),
+		Values: values,
+	}
+}
+
+// Reset replaces the collector with a new one, discarding state kept between
+// collections such as the baseline of Collector.Snapshot, and releases the
+// buffers.
+func (v *Var) Reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.collector = collector.New(nil)
+	v.enc = pointEncoder{}
+}
+
+// String implements expvar.Var by encoding the result of Collect.
+func (v *Var) String() string {
+	point := v.Collect()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.enc.encode(point)
+}
+
+// LatestVar is an expvar.Var which formats the statistics last passed to Set
+// as a JSON Point. Unlike Var it does not collect statistics itself, so it
+// can share the collection of a Collector with other sinks, e.g.
+//
+//	v := influxdb.NewLatestVar("my-measurement-name")
+//	expvar.Publish(os.Args[0], v)
+//	go collector.New(v.Set, otherSink).Run()
+type LatestVar struct {
+	measurement string
+
+	mu    sync.Mutex
+	point *Point
+	enc   pointEncoder
+}
+
+// NewLatestVar creates a LatestVar with the given measurement name. It encodes
+// to null until Set is called.
+func NewLatestVar(measurement string) *LatestVar {
+	return &LatestVar{measurement: measurement}
+}
+
+// Set replaces the statistics. It matches the signature of
+// collector.FieldsFunc.
+func (v *LatestVar) Set(fields collector.Fields) {
+	point := &Point{
+		Name:   v.measurement,
+		Tags:   fields.Tags(),
+		Values: fields,
+	}
+
+	v.mu.Lock()
+	v.point = point
+	v.mu.Unlock()
+}
+
+// String implements expvar.Var by encoding the statistics last passed to Set.
+func (v *LatestVar) String() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.point == nil {
+		return "null"
+	}
+
+	return v.enc.encode(v.point)
+}
+
+// pointEncoder encodes Points as JSON like encoding/json, reusing its buffers
+// between calls. It is not safe for concurrent use.
+type pointEncoder struct {
+	buf    []byte
+	values map[string]interface{}
+	keys   []string
+}
+
+func (e *pointEncoder) encode(point *Point) string {
+	if e.values == nil {
+		e.values = make(map[string]interface{})
+	}
+	for key := range e.values {
+		delete(e.values, key)
+	}
+	point.Values.ValuesInto(e.values)
+
+	buf := append(e.buf[:0], `{"name":`...)
+	buf = appendString(buf, point.Name)
+
+	buf = append(buf, `,"tags":{`...)
+	e.keys = appendSortedKeys(e.keys[:0], point.Tags)
+	for i, key := range e.keys {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendString(buf, key)
+		buf = append(buf, ':')
+		buf = appendString(buf, point.Tags[key])
+	}
+
+	buf = append(buf, `},"values":{`...)
+	e.keys = e.keys[:0]
+	for key := range e.values {
+		e.keys = append(e.keys, key)
+	}
+	sort.Strings(e.keys)
+	for i, key := range e.keys {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendString(buf, key)
+		buf = append(buf, ':')
+		buf = appendValue(buf, e.values[key])
+	}
+	buf = append(buf, "}}"...)
+
+	e.buf = buf
+	return string(buf)
+}
+
+func appendSortedKeys(keys []string, m map[string]string) []string {
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// appendString appends s as a JSON string. Strings only made up of printable
+// ASCII characters that need no escaping, like the statistic keys, are
+// appended as-is.
+func appendString(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c >= utf8.RuneSelf || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			b, _ := json.Marshal(s)
+			return append(buf, b...)
+		}
+	}
+
+	buf = append(buf, '"')
+	buf = append(buf, s...)
+	return append(buf, '"')
+}
+
+// appendValue appends a statistic as a JSON number. Non-finite floats, which
+// JSON cannot represent, are appended as null.
+func appendValue(buf []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case int64:
+		return strconv.AppendInt(buf, v, 10)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return append(buf, "null"...)
+		}
+		return strconv.AppendFloat(buf, v, 'g', -1, 64)
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return append(buf, "null"...)
+	}
+	return append(buf, b...)
+}
@@ -0,0 +1,117 @@
+package kafka
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tevjef/go-runtime-metrics/collector"
+	"github.com/tevjef/go-runtime-metrics/influxdb"
+)
+
+// Producer produces a message to a Kafka topic. It can wrap the producer of
+// any Kafka client, e.g. a synchronous sarama.SyncProducer or a
+// kafka.Writer of github.com/segmentio/kafka-go.
+type Producer interface {
+	Produce(topic string, key, value []byte) error
+}
+
+// Format of the produced messages
+type Format int
+
+const (
+	// JSON encodes every set of statistics as an object containing the keys
+	// returned by Fields.Values and Fields.Tags and a "time" key holding the
+	// RFC 3339 time of the collection, see collector.JSONSerializer.
+	JSON Format = iota
+
+	// LineProtocol encodes every set of statistics as a single InfluxDB line
+	// protocol point, see influxdb.AppendLine.
+	LineProtocol
+)
+
+// DefaultMeasurement is the measurement of points in LineProtocol format.
+const DefaultMeasurement = "go.runtime"
+
+// Sink produces every set of statistics as a message to a Kafka topic. The
+// messages are keyed by the hostname so that the statistics of a host end up
+// in the same partition and stay in order.
+//
+//	package main
+//
+//	import (
+//	   "github.com/tevjef/go-runtime-metrics/collector"
+//	   "github.com/tevjef/go-runtime-metrics/kafka"
+//	)
+//
+//	func main {
+//	    sink := kafka.New(producer, "runtime-metrics")
+//	    sink.Format = kafka.LineProtocol
+//	    go collector.New(sink.Send).Run()
+//	}
+type Sink struct {
+	Producer Producer
+
+	// Topic the statistics are produced to.
+	Topic string
+
+	// Format of the messages. Defaults to JSON.
+	Format Format
+
+	// Measurement of the points in LineProtocol format. Defaults to
+	// DefaultMeasurement.
+	Measurement string
+
+	// Key of every message. Defaults to the hostname.
+	Key string
+
+	// OnError is called with errors that caused statistics to be dropped, such as
+	// failed produces. Errors are ignored when nil.
+	OnError func(error)
+}
+
+// New creates a Sink producing JSON messages to topic with p.
+func New(p Producer, topic string) *Sink {
+	key, err := os.Hostname()
+	if err != nil {
+		key = "unknown"
+	}
+
+	return &Sink{
+		Producer:    p,
+		Topic:       topic,
+		Measurement: DefaultMeasurement,
+		Key:         key,
+	}
+}
+
+// Send produces fields as a message. It matches the signature of
+// collector.FieldsFunc.
+func (s *Sink) Send(fields collector.Fields) {
+	value, err := s.encode(fields, time.Now())
+	if err == nil {
+		err = errors.Wrapf(s.Producer.Produce(s.Topic, []byte(s.Key), value), "failed to produce to topic %s", s.Topic)
+	}
+
+	if err != nil && s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+func (s *Sink) encode(fields collector.Fields, t time.Time) ([]byte, error) {
+	tags := fields.Tags()
+
+	switch s.Format {
+	case JSON:
+		return collector.JSONSerializer{}.Serialize(fields, tags, t)
+	case LineProtocol:
+		measurement := s.Measurement
+		if measurement == "" {
+			measurement = DefaultMeasurement
+		}
+
+		return influxdb.AppendLine(nil, measurement, tags, fields.Values(), t), nil
+	}
+
+	return nil, errors.Errorf("unknown format %d", s.Format)
+}
@@ -0,0 +1,79 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+type message struct {
+	topic      string
+	key, value []byte
+}
+
+type fakeProducer struct {
+	messages []message
+	err      error
+}
+
+func (f *fakeProducer) Produce(topic string, key, value []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+
+	f.messages = append(f.messages, message{topic, key, value})
+	return nil
+}
+
+func TestSendJSON(t *testing.T) {
+	p := &fakeProducer{}
+	s := New(p, "runtime")
+	s.Key = "host-a"
+	s.Send(collector.New(nil).OneOff())
+
+	if len(p.messages) != 1 || p.messages[0].topic != "runtime" || string(p.messages[0].key) != "host-a" {
+		t.Fatalf("expected one message keyed host-a on runtime, got %v", p.messages)
+	}
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(p.messages[0].value, &values); err != nil {
+		t.Fatalf("invalid json message: %v", err)
+	}
+
+	for _, expKey := range []string{"cpu.goroutines", "go.version", "time"} {
+		if _, ok := values[expKey]; !ok {
+			t.Errorf("expected key (%s) not found", expKey)
+		}
+	}
+}
+
+func TestSendLineProtocol(t *testing.T) {
+	p := &fakeProducer{}
+	s := New(p, "runtime")
+	s.Format = LineProtocol
+	s.Send(collector.New(nil).OneOff())
+
+	if len(p.messages) != 1 {
+		t.Fatalf("expected one message, got %d", len(p.messages))
+	}
+
+	line := p.messages[0].value
+	if !bytes.HasPrefix(line, []byte(DefaultMeasurement+",")) || !bytes.Contains(line, []byte("cpu.goroutines=")) {
+		t.Errorf("unexpected line %q", line)
+	}
+}
+
+func TestSendError(t *testing.T) {
+	var errs []error
+	s := New(&fakeProducer{err: errors.New("broker unavailable")}, "runtime")
+	s.OnError = func(err error) { errs = append(errs, err) }
+	s.Send(collector.New(nil).OneOff())
+
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "broker unavailable") {
+		t.Errorf("expected the produce error to be passed to OnError, got %v", errs)
+	}
+}
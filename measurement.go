@@ -0,0 +1,55 @@
+package runstats
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Render Measurement as a template when it contains template actions. The
+// variables are Host, PID, Goos and Goarch, extended or overridden by
+// MeasurementVars. Referencing an undefined variable is an error.
+func (config *Config) measurement() (string, error) {
+	if !strings.Contains(config.Measurement, "{{") {
+		return config.Measurement, nil
+	}
+
+	tmpl, err := template.New("measurement").Option("missingkey=error").Parse(config.Measurement)
+
+	if err != nil {
+		return "", errors.Wrap(err, "invalid measurement template")
+	}
+
+	vars := map[string]string{
+		"Host":   hostname(),
+		"PID":    strconv.Itoa(os.Getpid()),
+		"Goos":   runtime.GOOS,
+		"Goarch": runtime.GOARCH,
+	}
+
+	for k, v := range config.MeasurementVars {
+		vars[k] = v
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", errors.Wrap(err, "failed to render measurement template")
+	}
+
+	return b.String(), nil
+}
+
+// Hostname of the machine or "unknown"
+func hostname() string {
+	hn, err := os.Hostname()
+
+	if err != nil {
+		return "unknown"
+	}
+
+	return hn
+}
@@ -0,0 +1,88 @@
+package runstats
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/pkg/errors"
+)
+
+// Writes to one of several InfluxDB hosts
+type hostWriter struct {
+	host    string
+	writer  batchWriter
+	onError func(error)
+}
+
+// Writes every batch to all hosts concurrently
+type multiWriter []hostWriter
+
+// Write reports the failure of each host to its onError and only returns an
+// error when the batch could not be written to any host. That error holds the
+// failures of all hosts, which are then not passed to onError.
+func (m multiWriter) Write(bp client.BatchPoints) error {
+	errs := make([]error, len(m))
+
+	var wg sync.WaitGroup
+	for i, w := range m {
+		wg.Add(1)
+		go func(i int, w hostWriter) {
+			defer wg.Done()
+			errs[i] = w.writer.Write(bp)
+		}(i, w)
+	}
+	wg.Wait()
+
+	var failed hostErrors
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, errors.Wrapf(err, "host %s", m[i].host))
+		}
+	}
+
+	if len(failed) == len(m) {
+		return errors.Wrap(failed, "could not write points to any InfluxDB host")
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			m[i].onError(classify(ErrWriteFailed, errors.Wrapf(err, "could not write points to InfluxDB host %s", m[i].host)))
+		}
+	}
+
+	return nil
+}
+
+// Failures of the writes to several hosts
+type hostErrors []error
+
+func (e hostErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap allows errors.Is and errors.As to match the failure of any host.
+func (e hostErrors) Unwrap() []error {
+	return e
+}
+
+// Closes the clients of several hosts
+type multiCloser []io.Closer
+
+// Close closes every client and returns the first error.
+func (m multiCloser) Close() error {
+	var first error
+
+	for _, c := range m {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,64 @@
+package nats
+
+import (
+	"time"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+// Publisher publishes a message to a subject. It is satisfied by *nats.Conn of
+// github.com/nats-io/nats.go and can wrap any other message queue client.
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// Sink publishes every set of statistics as a JSON object containing the keys
+// returned by Fields.Values and Fields.Tags and a "time" key holding the
+// RFC 3339 time of the collection, see collector.JSONSerializer.
+//
+//	package main
+//
+//	import (
+//	   "github.com/nats-io/nats.go"
+//	   "github.com/tevjef/go-runtime-metrics/collector"
+//	   metrics "github.com/tevjef/go-runtime-metrics/nats"
+//	)
+//
+//	func main {
+//	    nc, err := nats.Connect(nats.DefaultURL)
+//	    if err != nil {
+//	        // handle error
+//	    }
+//	    go collector.New(metrics.New(nc, "metrics.runtime").Send).Run()
+//	}
+type Sink struct {
+	Publisher Publisher
+
+	// Subject the statistics are published to.
+	Subject string
+
+	// OnError is called with errors that caused statistics to be dropped, such as
+	// failed publishes. Errors are ignored when nil.
+	OnError func(error)
+}
+
+// New creates a Sink publishing to subject with p.
+func New(p Publisher, subject string) *Sink {
+	return &Sink{
+		Publisher: p,
+		Subject:   subject,
+	}
+}
+
+// Send publishes fields as a JSON message. It matches the signature of
+// collector.FieldsFunc.
+func (s *Sink) Send(fields collector.Fields) {
+	data, err := collector.JSONSerializer{}.Serialize(fields, fields.Tags(), time.Now())
+	if err == nil {
+		err = s.Publisher.Publish(s.Subject, data)
+	}
+
+	if err != nil && s.OnError != nil {
+		s.OnError(err)
+	}
+}
@@ -0,0 +1,57 @@
+package nats
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+type fakePublisher struct {
+	subjects []string
+	messages [][]byte
+	err      error
+}
+
+func (f *fakePublisher) Publish(subject string, data []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+
+	f.subjects = append(f.subjects, subject)
+	f.messages = append(f.messages, data)
+	return nil
+}
+
+func TestSend(t *testing.T) {
+	p := &fakePublisher{}
+	New(p, "metrics.runtime").Send(collector.New(nil).OneOff())
+
+	if len(p.messages) != 1 || p.subjects[0] != "metrics.runtime" {
+		t.Fatalf("expected one message on metrics.runtime, got %v", p.subjects)
+	}
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(p.messages[0], &values); err != nil {
+		t.Fatalf("invalid json message: %v", err)
+	}
+
+	for _, expKey := range []string{"cpu.goroutines", "go.version", "time"} {
+		if _, ok := values[expKey]; !ok {
+			t.Errorf("expected key (%s) not found", expKey)
+		}
+	}
+}
+
+func TestSendError(t *testing.T) {
+	var errs []error
+
+	s := New(&fakePublisher{err: errors.New("disconnected")}, "metrics.runtime")
+	s.OnError = func(err error) { errs = append(errs, err) }
+	s.Send(collector.New(nil).OneOff())
+
+	if len(errs) != 1 {
+		t.Errorf("expected the publish error to be reported, got %v", errs)
+	}
+}
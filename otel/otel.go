@@ -0,0 +1,107 @@
+package otel
+
+import (
+	"context"
+	"sort"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Register creates an asynchronous gauge on meter for every statistic gathered
+// by c and registers a callback observing them with collector.Collector.OneOff.
+// Instruments are named after the statistic key prefixed with "go.", e.g.
+// "go.mem.heap.alloc", and carry the collector tags as attributes. If c is nil a
+// collector with the default settings is used. Call Unregister on the returned
+// registration to stop observing.
+//
+//	package main
+//
+//	import (
+//	   "go.opentelemetry.io/otel"
+//	   metrics "github.com/tevjef/go-runtime-metrics/otel"
+//	)
+//
+//	func main {
+//	    if _, err := metrics.Register(otel.Meter("runtime"), nil); err != nil {
+//	        // handle error
+//	    }
+//	}
+func Register(meter metric.Meter, c *collector.Collector) (metric.Registration, error) {
+	if c == nil {
+		c = collector.New(nil)
+	}
+
+	fields := c.OneOff()
+	values := fields.Values()
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	o := &observer{
+		collector: c,
+		ints:      map[string]metric.Int64ObservableGauge{},
+		floats:    map[string]metric.Float64ObservableGauge{},
+	}
+
+	instruments := make([]metric.Observable, 0, len(keys))
+	for _, key := range keys {
+		name := "go." + key
+		desc := metric.WithDescription("Go runtime statistic " + key + ".")
+
+		switch values[key].(type) {
+		case int64:
+			gauge, err := meter.Int64ObservableGauge(name, desc)
+			if err != nil {
+				return nil, err
+			}
+			o.ints[key] = gauge
+			instruments = append(instruments, gauge)
+		case float64:
+			gauge, err := meter.Float64ObservableGauge(name, desc)
+			if err != nil {
+				return nil, err
+			}
+			o.floats[key] = gauge
+			instruments = append(instruments, gauge)
+		}
+	}
+
+	return meter.RegisterCallback(o.observe, instruments...)
+}
+
+type observer struct {
+	collector *collector.Collector
+	ints      map[string]metric.Int64ObservableGauge
+	floats    map[string]metric.Float64ObservableGauge
+}
+
+func (o *observer) observe(_ context.Context, obs metric.Observer) error {
+	fields := o.collector.OneOff()
+
+	tags := fields.Tags()
+	attrs := make([]attribute.KeyValue, 0, len(tags))
+	for k, v := range tags {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	opt := metric.WithAttributes(attrs...)
+
+	for key, value := range fields.Values() {
+		switch v := value.(type) {
+		case int64:
+			if gauge, ok := o.ints[key]; ok {
+				obs.ObserveInt64(gauge, v, opt)
+			}
+		case float64:
+			if gauge, ok := o.floats[key]; ok {
+				obs.ObserveFloat64(gauge, v, opt)
+			}
+		}
+	}
+
+	return nil
+}
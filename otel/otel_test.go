@@ -0,0 +1,52 @@
+package otel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+type countingObserver struct {
+	metric.Observer
+	ints, floats int
+}
+
+func (c *countingObserver) ObserveInt64(metric.Int64Observable, int64, ...metric.ObserveOption) {
+	c.ints++
+}
+
+func (c *countingObserver) ObserveFloat64(metric.Float64Observable, float64, ...metric.ObserveOption) {
+	c.floats++
+}
+
+func TestRegister(t *testing.T) {
+	reg, err := Register(noop.NewMeterProvider().Meter("test"), nil)
+	if err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	defer reg.Unregister()
+}
+
+func TestObserve(t *testing.T) {
+	meter := noop.NewMeterProvider().Meter("test")
+	gaugeInt, _ := meter.Int64ObservableGauge("go.cpu.goroutines")
+	gaugeFloat, _ := meter.Float64ObservableGauge("go.mem.gc.cpu_fraction")
+
+	o := &observer{
+		collector: collector.New(nil),
+		ints:      map[string]metric.Int64ObservableGauge{"cpu.goroutines": gaugeInt},
+		floats:    map[string]metric.Float64ObservableGauge{"mem.gc.cpu_fraction": gaugeFloat},
+	}
+
+	obs := &countingObserver{}
+	if err := o.observe(context.Background(), obs); err != nil {
+		t.Fatal(err)
+	}
+
+	if obs.ints != 1 || obs.floats != 1 {
+		t.Errorf("expected one int and one float observation, got (%d, %d)", obs.ints, obs.floats)
+	}
+}
@@ -0,0 +1,182 @@
+package prometheus
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+// contentType is the content type of the OpenMetrics text format.
+const contentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// HandlerOption configures the http.Handler returned by Handler.
+type HandlerOption func(*handler)
+
+// WithCollector makes the handler read statistics from c instead of a
+// collector with the default settings.
+func WithCollector(c *collector.Collector) HandlerOption {
+	return func(h *handler) { h.collector = c }
+}
+
+// WithLabels attaches labels to every metric.
+func WithLabels(labels map[string]string) HandlerOption {
+	return func(h *handler) {
+		for k, v := range labels {
+			h.labels[k] = v
+		}
+	}
+}
+
+type handler struct {
+	collector *collector.Collector
+	labels    map[string]string
+}
+
+// Handler returns a http.Handler which responds to every scrape with freshly
+// collected statistics in the OpenMetrics text format, without registering
+// with a prometheus.Registry. The metrics are named and typed like the ones
+// of Collector, counters get the _total suffix required by OpenMetrics, e.g.
+// "mem.gc.count" becomes "go_mem_gc_count_total".
+//
+//	package main
+//
+//	import (
+//	   "net/http"
+//	   metrics "github.com/tevjef/go-runtime-metrics/prometheus"
+//	)
+//
+//	func main {
+//	    http.Handle("/metrics", metrics.Handler(metrics.WithLabels(map[string]string{"service": "api"})))
+//	    http.ListenAndServe(":9100", nil)
+//	}
+func Handler(opts ...HandlerOption) http.Handler {
+	h := &handler{labels: map[string]string{}}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	if h.collector == nil {
+		h.collector = collector.New(nil)
+	}
+
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fields := h.collector.OneOff()
+	buf := appendText(nil, fields, fields.Tags(), h.labels)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(buf)
+}
+
+// TextSerializer is a collector.Serializer encoding statistics in the
+// OpenMetrics text format served by Handler, e.g. to write them to a file
+// read by the textfile collector of the node exporter. The time is ignored,
+// the samples are stamped by the scraper.
+type TextSerializer struct {
+	// Labels attached to every metric.
+	Labels map[string]string
+}
+
+// Serialize implements collector.Serializer.
+func (s TextSerializer) Serialize(fields collector.Fields, tags map[string]string, t time.Time) ([]byte, error) {
+	return appendText(nil, fields, tags, s.Labels), nil
+}
+
+// appendText appends the statistics in the OpenMetrics text format to buf,
+// with the tags as the labels of an info metric.
+func appendText(buf []byte, fields collector.Fields, tags, labels map[string]string) []byte {
+	values := fields.Values()
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	constLabels := make(map[string]string, len(labels))
+	for k, v := range labels {
+		constLabels[LabelName(k)] = v
+	}
+	sampleLabels := appendLabels(nil, constLabels)
+
+	for _, key := range keys {
+		value, ok := toFloat(values[key])
+		if !ok {
+			continue
+		}
+
+		name, typ, sample := MetricName(key), "gauge", MetricName(key)
+		if meta, _ := collector.LookupFieldMeta(key); meta.Kind == collector.Counter {
+			typ, sample = "counter", name+"_total"
+		}
+
+		buf = append(buf, "# TYPE "+name+" "+typ+"\n"...)
+		buf = append(buf, "# HELP "+name+" Go runtime statistic "+key+".\n"...)
+		buf = append(buf, sample...)
+		buf = append(buf, sampleLabels...)
+		buf = append(buf, " "+formatFloat(value)+"\n"...)
+	}
+
+	// The tags are exported as the labels of an info metric.
+	info := strings.TrimSuffix(infoName, "_info")
+	infoLabels := make(map[string]string, len(tags)+len(labels))
+	for k, v := range tags {
+		infoLabels[LabelName(k)] = v
+	}
+	for k, v := range constLabels {
+		infoLabels[k] = v
+	}
+
+	buf = append(buf, "# TYPE "+info+" info\n"...)
+	buf = append(buf, "# HELP "+info+" Information about the Go runtime.\n"...)
+	buf = append(buf, infoName...)
+	buf = appendLabels(buf, infoLabels)
+	return append(buf, " 1\n# EOF\n"...)
+}
+
+// appendLabels appends labels sorted by name in the exposition format, e.g.
+// {service="api"}. Nothing is appended without labels.
+func appendLabels(buf []byte, labels map[string]string) []byte {
+	if len(labels) == 0 {
+		return buf
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf = append(buf, '{')
+	for i, name := range names {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, name...)
+		buf = append(buf, `="`...)
+		buf = append(buf, labelValueReplacer.Replace(labels[name])...)
+		buf = append(buf, '"')
+	}
+	return append(buf, '}')
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
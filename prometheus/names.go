@@ -0,0 +1,70 @@
+package prometheus
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// MetricName converts a statistic key into a valid Prometheus metric name in
+// the go namespace, e.g. "mem.heap.alloc" becomes "go_mem_heap_alloc". It is
+// shared by the exporters of this module so they name metrics alike. The names
+// of the statistics are distinct, as their keys only differ in dotted
+// segments made up of lowercase letters, digits and underscores.
+func MetricName(key string) string {
+	name := sanitize(key)
+	if strings.HasPrefix(name, namespace+"_") {
+		return name
+	}
+	return namespace + "_" + name
+}
+
+// LabelName converts a tag key into a valid Prometheus label name, e.g.
+// "go.os" becomes "go_os" and "1st" becomes "_1st". Names starting with an
+// underscore, such as the reserved "__name__", are prefixed with an x, so that
+// "_1st" becomes "x_1st" and does not collide with "1st".
+func LabelName(key string) string {
+	name := sanitize(key)
+	switch {
+	case name == "":
+		return "_"
+	case strings.HasPrefix(name, "_") && !isDigitPrefixed(key):
+		return "x" + name
+	}
+	return name
+}
+
+// ValidMetricName reports whether name is a valid Prometheus metric name.
+func ValidMetricName(name string) bool {
+	return metricNameRE.MatchString(name)
+}
+
+// ValidLabelName reports whether name is a valid Prometheus label name that is
+// not reserved.
+func ValidLabelName(name string) bool {
+	return labelNameRE.MatchString(name) && !strings.HasPrefix(name, "__")
+}
+
+// sanitize replaces every character that is invalid in a name by an
+// underscore and prefixes names starting with a digit with one.
+func sanitize(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			b[i] = '_'
+		}
+	}
+
+	if isDigitPrefixed(s) {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+func isDigitPrefixed(s string) bool {
+	return len(s) > 0 && s[0] >= '0' && s[0] <= '9'
+}
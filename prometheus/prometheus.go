@@ -0,0 +1,125 @@
+package prometheus
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+const (
+	namespace = "go"
+	infoName  = "go_runtime_info"
+)
+
+// Collector implements prometheus.Collector by gathering runtime statistics
+// with collector.Collector.OneOff on every scrape. Each value is exported as a
+// gauge, or a counter if collector.LookupFieldMeta reports one, named after its
+// key, e.g. "mem.heap.alloc" becomes "go_mem_heap_alloc".
+//
+//	package main
+//
+//	import (
+//	   "github.com/prometheus/client_golang/prometheus"
+//	   metrics "github.com/tevjef/go-runtime-metrics/prometheus"
+//	)
+//
+//	func main {
+//	    registry := prometheus.NewRegistry()
+//	    registry.MustRegister(metrics.New(nil, prometheus.Labels{"service": "api"}))
+//	}
+type Collector struct {
+	collector *collector.Collector
+	descs     map[string]*prometheus.Desc
+	keys      []string
+	info      *prometheus.Desc
+	infoTags  []string
+}
+
+// New creates a Collector that reads statistics from c. If c is nil a collector
+// with the default settings is used. constLabels are attached to every metric,
+// their names are converted with LabelName.
+func New(c *collector.Collector, constLabels prometheus.Labels) *Collector {
+	if c == nil {
+		c = collector.New(nil)
+	}
+
+	labels := make(prometheus.Labels, len(constLabels))
+	for k, v := range constLabels {
+		labels[LabelName(k)] = v
+	}
+	constLabels = labels
+
+	fields := c.OneOff()
+	values := fields.Values()
+
+	p := &Collector{
+		collector: c,
+		descs:     make(map[string]*prometheus.Desc, len(values)),
+		keys:      make([]string, 0, len(values)),
+	}
+
+	for key := range values {
+		p.keys = append(p.keys, key)
+		p.descs[key] = prometheus.NewDesc(MetricName(key), "Go runtime statistic "+key+".", nil, constLabels)
+	}
+	sort.Strings(p.keys)
+
+	// The label set of the info metric is fixed to the tags known at creation.
+	for tag := range fields.Tags() {
+		p.infoTags = append(p.infoTags, tag)
+	}
+	sort.Slice(p.infoTags, func(i, j int) bool { return LabelName(p.infoTags[i]) < LabelName(p.infoTags[j]) })
+
+	infoLabels := make([]string, 0, len(p.infoTags))
+	for _, tag := range p.infoTags {
+		infoLabels = append(infoLabels, LabelName(tag))
+	}
+	p.info = prometheus.NewDesc(infoName, "Information about the Go runtime.", infoLabels, constLabels)
+
+	return p
+}
+
+// Describe implements prometheus.Collector.
+func (p *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, key := range p.keys {
+		ch <- p.descs[key]
+	}
+	ch <- p.info
+}
+
+// Collect implements prometheus.Collector.
+func (p *Collector) Collect(ch chan<- prometheus.Metric) {
+	fields := p.collector.OneOff()
+	values := fields.Values()
+
+	for _, key := range p.keys {
+		value, ok := toFloat(values[key])
+		if !ok {
+			continue
+		}
+
+		valueType := prometheus.GaugeValue
+		if meta, _ := collector.LookupFieldMeta(key); meta.Kind == collector.Counter {
+			valueType = prometheus.CounterValue
+		}
+		ch <- prometheus.MustNewConstMetric(p.descs[key], valueType, value)
+	}
+
+	tags := fields.Tags()
+	labelValues := make([]string, 0, len(p.infoTags))
+	for _, tag := range p.infoTags {
+		labelValues = append(labelValues, tags[tag])
+	}
+	ch <- prometheus.MustNewConstMetric(p.info, prometheus.GaugeValue, 1, labelValues...)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,136 @@
+package prometheus
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+func TestCollector(t *testing.T) {
+	c := New(nil, prometheus.Labels{"service": "test"})
+
+	if err := prometheus.NewRegistry().Register(c); err != nil {
+		t.Fatalf("failed to register collector: %v", err)
+	}
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	found := map[string]bool{}
+	for m := range ch {
+		desc := m.Desc().String()
+		for _, name := range []string{"go_cpu_goroutines", "go_mem_heap_alloc", "go_mem_gc_count", infoName} {
+			if strings.Contains(desc, `"`+name+`"`) {
+				found[name] = true
+			}
+		}
+	}
+
+	for _, name := range []string{"go_cpu_goroutines", "go_mem_heap_alloc", "go_mem_gc_count", infoName} {
+		if !found[name] {
+			t.Errorf("expected metric (%s) not found", name)
+		}
+	}
+}
+
+func TestMetricName(t *testing.T) {
+	if name := MetricName("mem.heap.alloc"); name != "go_mem_heap_alloc" {
+		t.Errorf("expected name (go_mem_heap_alloc) got (%s)", name)
+	}
+	if name := MetricName("go.os"); name != "go_os" {
+		t.Errorf("expected name (go_os) got (%s)", name)
+	}
+	if name := MetricName("9 lives-left"); name != "go__9_lives_left" || !ValidMetricName(name) {
+		t.Errorf("expected name (go__9_lives_left) got (%s)", name)
+	}
+
+	fields := collector.New(nil).OneOff()
+	names := map[string]string{}
+	for key := range fields.Values() {
+		name := MetricName(key)
+		if !ValidMetricName(name) {
+			t.Errorf("invalid name (%s) for key (%s)", name, key)
+		}
+		if other, ok := names[name]; ok {
+			t.Errorf("keys (%s) and (%s) map to the same name (%s)", key, other, name)
+		}
+		if again := MetricName(name); again != name {
+			t.Errorf("expected name (%s) to be stable, got (%s)", name, again)
+		}
+		names[name] = key
+	}
+}
+
+func TestLabelName(t *testing.T) {
+	for key, exp := range map[string]string{
+		"go.os":     "go_os",
+		"service":   "service",
+		"__name__":  "x__name__",
+		"1st.shard": "_1st_shard",
+		"_1x":       "x_1x",
+		"1x":        "_1x",
+		".x":        "x_x",
+		"":          "_",
+	} {
+		name := LabelName(key)
+		if name != exp {
+			t.Errorf("expected label (%s) for (%s), got (%s)", exp, key, name)
+		}
+		if !ValidLabelName(name) {
+			t.Errorf("expected a valid label for (%s), got (%s)", key, name)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler(WithLabels(map[string]string{"service": "te\"st"})).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Errorf("unexpected content type %s", ct)
+	}
+
+	body := rec.Body.String()
+	for _, exp := range []string{
+		"# TYPE go_mem_heap_alloc gauge\n",
+		"# HELP go_mem_heap_alloc Go runtime statistic mem.heap.alloc.\n",
+		"go_mem_heap_alloc{service=\"te\\\"st\"} ",
+		"# TYPE go_mem_gc_count counter\n",
+		"go_mem_gc_count_total{service=\"te\\\"st\"} ",
+		"# TYPE go_runtime info\n",
+		"go_runtime_info{go_arch=",
+	} {
+		if !strings.Contains(body, exp) {
+			t.Errorf("expected %q in output", exp)
+		}
+	}
+
+	if !strings.HasSuffix(body, "# EOF\n") {
+		t.Error("expected the output to end with # EOF")
+	}
+}
+
+func TestTextSerializer(t *testing.T) {
+	fields := collector.Fields{NumGoroutine: 3}
+	b, err := TextSerializer{Labels: map[string]string{"service": "api"}}.Serialize(fields, map[string]string{"go.os": "linux"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := string(b)
+	for _, exp := range []string{
+		"go_cpu_goroutines{service=\"api\"} 3\n",
+		"go_runtime_info{go_os=\"linux\",service=\"api\"} 1\n",
+	} {
+		if !strings.Contains(text, exp) {
+			t.Errorf("expected %q in output", exp)
+		}
+	}
+}
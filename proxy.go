@@ -0,0 +1,25 @@
+package runstats
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// Build the proxy function used to connect to InfluxDB. It uses HTTPProxy when
+// set and the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+// otherwise
+func (config *Config) proxy() (func(*http.Request) (*url.URL, error), error) {
+	if config.HTTPProxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	proxyURL, err := url.Parse(config.HTTPProxy)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid HTTP proxy")
+	}
+
+	return http.ProxyURL(proxyURL), nil
+}
@@ -0,0 +1,68 @@
+package runstats
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimitedLogger wraps a Logger so that a message logged with Println is
+// only logged once per Window, e.g. the error of every failed write during an
+// outage of InfluxDB. At the end of the window the number of times the message
+// was suppressed is logged, the next occurrence starts a new window. Messages
+// logged with Fatalln are never suppressed.
+//
+//	runstats.RunCollector(&runstats.Config{
+//	    Logger: runstats.NewRateLimitedLogger(&runstats.StdLogger{}, time.Minute),
+//	})
+type RateLimitedLogger struct {
+	Logger Logger
+	Window time.Duration
+
+	mu         sync.Mutex
+	suppressed map[string]int
+}
+
+// NewRateLimitedLogger creates a RateLimitedLogger logging to logger at most
+// once per window for every message.
+func NewRateLimitedLogger(logger Logger, window time.Duration) *RateLimitedLogger {
+	return &RateLimitedLogger{Logger: logger, Window: window}
+}
+
+func (l *RateLimitedLogger) Println(v ...interface{}) {
+	if l.Window <= 0 {
+		l.Logger.Println(v...)
+		return
+	}
+
+	msg := fmt.Sprint(v...)
+
+	l.mu.Lock()
+	if _, ok := l.suppressed[msg]; ok {
+		l.suppressed[msg]++
+		l.mu.Unlock()
+		return
+	}
+	if l.suppressed == nil {
+		l.suppressed = make(map[string]int)
+	}
+	l.suppressed[msg] = 0
+	l.mu.Unlock()
+
+	time.AfterFunc(l.Window, func() { l.summarize(msg) })
+	l.Logger.Println(v...)
+}
+
+func (l *RateLimitedLogger) Fatalln(v ...interface{}) { l.Logger.Fatalln(v...) }
+
+// summarize ends the window of msg and logs how often it was suppressed.
+func (l *RateLimitedLogger) summarize(msg string) {
+	l.mu.Lock()
+	n := l.suppressed[msg]
+	delete(l.suppressed, msg)
+	l.mu.Unlock()
+
+	if n > 0 {
+		l.Logger.Println(fmt.Sprintf("message repeated %d more times in %v: %s", n, l.Window, msg))
+	}
+}
@@ -0,0 +1,295 @@
+package remotewrite
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/tevjef/go-runtime-metrics/collector"
+	"github.com/tevjef/go-runtime-metrics/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	defaultBatchInterval = 60 * time.Second
+	defaultTimeout       = 10 * time.Second
+)
+
+// Config configures the Client.
+type Config struct {
+	// URL of the remote-write endpoint, e.g.
+	// "http://mimir:8080/api/v1/push". Required.
+	URL string
+
+	// Credentials for basic authentication. The requests are not authenticated
+	// when Username is empty.
+	Username string
+	Password string
+
+	// Additional labels attached to every series, e.g. {"job": "api"}. The keys
+	// returned by Fields.Tags are always attached. The names are converted with
+	// prometheus.LabelName.
+	Labels map[string]string
+
+	// Interval in which the collected statistics are sent.
+	// Default is 60 seconds
+	BatchInterval time.Duration
+
+	// HTTP client used to send the requests.
+	// Default is a client with a 10 second timeout
+	HTTPClient *http.Client
+
+	// Called with errors that caused samples to be dropped, such as failed
+	// requests. Errors are ignored when nil.
+	OnError func(error)
+}
+
+// Client sends collected statistics to a Prometheus remote-write endpoint, such
+// as the ones of Cortex, Mimir or Thanos, in batches. Every request is a
+// snappy-compressed, protobuf-encoded WriteRequest holding a time series per
+// statistic, named like the metrics of prometheus.Collector, e.g.
+// "mem.gc.count" becomes "go_mem_gc_count".
+//
+//	package main
+//
+//	import (
+//	   "github.com/tevjef/go-runtime-metrics/collector"
+//	   "github.com/tevjef/go-runtime-metrics/remotewrite"
+//	)
+//
+//	func main {
+//	    client, err := remotewrite.New(remotewrite.Config{URL: "http://mimir:8080/api/v1/push"})
+//	    if err != nil {
+//	        // handle error
+//	    }
+//	    defer client.Close()
+//	    go collector.New(client.Send).Run()
+//	}
+type Client struct {
+	config Config
+
+	mu     sync.Mutex
+	series map[string]*series
+
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+type label struct {
+	name, value string
+}
+
+type sample struct {
+	value     float64
+	timestamp int64
+}
+
+type series struct {
+	labels  []label
+	samples []sample
+}
+
+// New creates a Client and starts sending every BatchInterval. Call Close to
+// send the remaining statistics and stop.
+func New(config Config) (*Client, error) {
+	if config.URL == "" {
+		return nil, errors.New("remote write requires a url")
+	}
+
+	if config.BatchInterval <= 0 {
+		config.BatchInterval = defaultBatchInterval
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: defaultTimeout}
+	}
+
+	c := &Client{
+		config:  config,
+		series:  map[string]*series{},
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go c.loop()
+
+	return c, nil
+}
+
+// Send adds every value of fields to the next request. It matches the
+// signature of collector.FieldsFunc.
+func (c *Client) Send(fields collector.Fields) {
+	names := map[string]string{}
+	for k, v := range fields.Tags() {
+		names[prometheus.LabelName(k)] = v
+	}
+	for k, v := range c.config.Labels {
+		names[prometheus.LabelName(k)] = v
+	}
+
+	labels := make([]label, 0, len(names))
+	for name, value := range names {
+		labels = append(labels, label{name: name, value: value})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+
+	var key strings.Builder
+	for _, l := range labels {
+		key.WriteString("\x00" + l.name + "\x00" + l.value)
+	}
+	labelsKey := key.String()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, value := range fields.Values() {
+		var v float64
+		switch value := value.(type) {
+		case int64:
+			v = float64(value)
+		case float64:
+			v = value
+		default:
+			continue
+		}
+
+		name := prometheus.MetricName(key)
+		s, ok := c.series[name+labelsKey]
+		if !ok {
+			// Remote write requires the labels of a series to be sorted by name.
+			s = &series{labels: append([]label{{name: "__name__", value: name}}, labels...)}
+			sort.Slice(s.labels, func(i, j int) bool { return s.labels[i].name < s.labels[j].name })
+			c.series[name+labelsKey] = s
+		}
+		s.samples = append(s.samples, sample{value: v, timestamp: now})
+	}
+}
+
+// Flush sends the statistics added since the last request.
+func (c *Client) Flush() error {
+	c.mu.Lock()
+	batch := c.series
+	c.series = map[string]*series{}
+	c.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.config.URL, bytes.NewReader(snappy.Encode(nil, encodeWriteRequest(batch))))
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if c.config.Username != "" {
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+
+	resp, err := c.config.HTTPClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send samples")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.Errorf("failed to send samples: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// Close sends the remaining statistics and stops the Client.
+func (c *Client) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+	})
+	<-c.stopped
+
+	return c.Flush()
+}
+
+func (c *Client) loop() {
+	defer close(c.stopped)
+
+	tick := time.NewTicker(c.config.BatchInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-tick.C:
+			if err := c.Flush(); err != nil && c.config.OnError != nil {
+				c.config.OnError(err)
+			}
+		}
+	}
+}
+
+// Field numbers of the prometheus.WriteRequest protobuf message and the
+// messages it contains.
+const (
+	writeRequestTimeseries protowire.Number = 1
+
+	timeSeriesLabels  protowire.Number = 1
+	timeSeriesSamples protowire.Number = 2
+
+	labelName  protowire.Number = 1
+	labelValue protowire.Number = 2
+
+	sampleValue     protowire.Number = 1
+	sampleTimestamp protowire.Number = 2
+)
+
+// encodeWriteRequest encodes the series as a prometheus.WriteRequest. The
+// series are sorted by their labels, which keeps the requests reproducible.
+func encodeWriteRequest(batch map[string]*series) []byte {
+	keys := make([]string, 0, len(batch))
+	for key := range batch {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf, ts, msg []byte
+	for _, key := range keys {
+		s := batch[key]
+
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = protowire.AppendTag(msg[:0], labelName, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.name)
+			msg = protowire.AppendTag(msg, labelValue, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.value)
+
+			ts = protowire.AppendTag(ts, timeSeriesLabels, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, msg)
+		}
+		for _, smpl := range s.samples {
+			msg = protowire.AppendTag(msg[:0], sampleValue, protowire.Fixed64Type)
+			msg = protowire.AppendFixed64(msg, math.Float64bits(smpl.value))
+			msg = protowire.AppendTag(msg, sampleTimestamp, protowire.VarintType)
+			msg = protowire.AppendVarint(msg, uint64(smpl.timestamp))
+
+			ts = protowire.AppendTag(ts, timeSeriesSamples, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, msg)
+		}
+
+		buf = protowire.AppendTag(buf, writeRequestTimeseries, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+	}
+	return buf
+}
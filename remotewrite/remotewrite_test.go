@@ -0,0 +1,163 @@
+package remotewrite
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/tevjef/go-runtime-metrics/collector"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodedSeries is a time series decoded from a WriteRequest.
+type decodedSeries struct {
+	labels  map[string]string
+	samples []sample
+}
+
+// fieldsOf splits a protobuf message into the values of its fields.
+func fieldsOf(t *testing.T, b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte)) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal("invalid tag")
+		}
+		b = b[n:]
+
+		m := protowire.ConsumeFieldValue(num, typ, b)
+		if m < 0 {
+			t.Fatal("invalid field value")
+		}
+		v := b[:m]
+		if typ == protowire.BytesType {
+			v, _ = protowire.ConsumeBytes(v)
+		}
+		fn(num, typ, v)
+		b = b[m:]
+	}
+}
+
+func decodeWriteRequest(t *testing.T, b []byte) map[string]decodedSeries {
+	result := map[string]decodedSeries{}
+	fieldsOf(t, b, func(_ protowire.Number, _ protowire.Type, ts []byte) {
+		s := decodedSeries{labels: map[string]string{}}
+		var names []string
+		fieldsOf(t, ts, func(num protowire.Number, _ protowire.Type, v []byte) {
+			switch num {
+			case timeSeriesLabels:
+				var l label
+				fieldsOf(t, v, func(num protowire.Number, _ protowire.Type, v []byte) {
+					if num == labelName {
+						l.name = string(v)
+					} else {
+						l.value = string(v)
+					}
+				})
+				s.labels[l.name] = l.value
+				names = append(names, l.name)
+			case timeSeriesSamples:
+				var smpl sample
+				fieldsOf(t, v, func(num protowire.Number, _ protowire.Type, v []byte) {
+					if num == sampleValue {
+						bits, _ := protowire.ConsumeFixed64(v)
+						smpl.value = math.Float64frombits(bits)
+					} else {
+						ms, _ := protowire.ConsumeVarint(v)
+						smpl.timestamp = int64(ms)
+					}
+				})
+				s.samples = append(s.samples, smpl)
+			}
+		})
+
+		for i := 1; i < len(names); i++ {
+			if names[i-1] >= names[i] {
+				t.Errorf("expected sorted labels, got %v", names)
+			}
+		}
+		result[s.labels["__name__"]] = s
+	})
+	return result
+}
+
+func TestFlush(t *testing.T) {
+	var body []byte
+	var headers http.Header
+	var user, password string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		user, password, _ = r.BasicAuth()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client, err := New(Config{
+		URL:           server.URL,
+		Username:      "user",
+		Password:      "secret",
+		Labels:        map[string]string{"job": "test"},
+		BatchInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := collector.New(nil)
+	client.Send(c.OneOff())
+	client.Send(c.OneOff())
+
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if user != "user" || password != "secret" {
+		t.Errorf("expected basic auth, got (%s, %s)", user, password)
+	}
+	if headers.Get("Content-Encoding") != "snappy" || headers.Get("Content-Type") != "application/x-protobuf" {
+		t.Errorf("unexpected headers %v", headers)
+	}
+
+	decoded, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	series := decodeWriteRequest(t, decoded)
+	s, ok := series["go_cpu_goroutines"]
+	if !ok {
+		t.Fatalf("expected a go_cpu_goroutines series, got %d series", len(series))
+	}
+	if len(s.samples) != 2 || s.samples[0].value <= 0 || s.samples[0].timestamp <= 0 {
+		t.Errorf("expected both collections in one series, got %+v", s.samples)
+	}
+	if s.labels["job"] != "test" || s.labels["go_os"] == "" {
+		t.Errorf("expected the configured labels and the tags, got %v", s.labels)
+	}
+}
+
+func TestFlushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	client, err := New(Config{URL: server.URL, BatchInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.Send(collector.New(nil).OneOff())
+	if err := client.Close(); err == nil {
+		t.Error("expected an error for a failed request")
+	}
+
+	if _, err := New(Config{}); err == nil {
+		t.Error("expected an error without a url")
+	}
+}
@@ -1,8 +1,17 @@
 package runstats
 
 import (
+	"context"
+	"crypto/tls"
+	"expvar"
+	"io"
 	"log"
+	"math"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"fmt"
@@ -10,6 +19,7 @@ import (
 	"github.com/influxdata/influxdb/client/v2"
 	"github.com/pkg/errors"
 	"github.com/tevjef/go-runtime-metrics/collector"
+	"github.com/tevjef/go-runtime-metrics/influxdb"
 )
 
 const (
@@ -18,6 +28,41 @@ const (
 	defaultDatabase           = "stats"
 	defaultCollectionInterval = 10 * time.Second
 	defaultBatchInterval      = 60 * time.Second
+	defaultPointBufferSize    = 64
+	defaultStartupRetryWait   = time.Second
+	pingTimeout               = 5 * time.Second
+	processTagKey             = "process"
+)
+
+// Divisors of the byte-valued fields by Config.ByteUnit
+var byteUnits = map[string]float64{
+	"bytes": 1,
+	"kb":    1 << 10,
+	"mb":    1 << 20,
+	"gb":    1 << 30,
+}
+
+// Supported values of Config.Precision
+var precisions = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// BackpressurePolicy decides what happens to a collected point when the queue
+// of points waiting to be added to the batch is full.
+type BackpressurePolicy int
+
+const (
+	// DropNewest discards the point that was just collected.
+	DropNewest BackpressurePolicy = iota
+
+	// DropOldest discards the oldest queued point to make room.
+	DropOldest
+
+	// Block waits until there is room, delaying the next collection.
+	Block
 )
 
 // A configuration with default values.
@@ -28,47 +73,320 @@ type Config struct {
 	// Default is "localhost:8086".
 	Host string
 
+	// Write to InfluxDB over UDP instead of HTTP. Host and Hosts are the UDP
+	// addresses. UDP does not support creating the database, which must be
+	// configured on the UDP listener of InfluxDB, and write errors of the
+	// server are not reported.
+	// Default is false
+	UseUDP bool
+
+	// Maximum size in bytes of every UDP payload. Batches are split into
+	// payloads of at most this size and points too large for a payload are
+	// split by their fields.
+	// Default is 512
+	PayloadSize int
+
+	// Path of a Unix domain socket to stream line protocol to instead of
+	// writing to InfluxDB over HTTP, e.g. the socket_listener input of Telegraf
+	// on the same host. Host, Hosts, V2 and the database settings are ignored.
+	// The connection is re-established when a write fails, e.g. because
+	// Telegraf restarted. Delivery is at least once, the line interrupted by a
+	// failed write is sent again.
+	SocketPath string
+
+	// Additional InfluxDB host:port pairs every batch is also written to, e.g.
+	// for redundancy. A failed write to one host is passed to OnError and does
+	// not affect the other hosts. The batch is only kept for a retry when the
+	// writes to all hosts failed.
+	Hosts []string
+
+	// TLS configuration used to connect to InfluxDB over HTTPS.
+	TLSConfig *tls.Config
+
+	// PEM encoded client certificate and key files for mutual TLS, and CA file
+	// used to verify the server. Only used when TLSConfig is nil, setting any of
+	// them connects over HTTPS.
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSCAFile   string
+
+	// URL of the HTTP proxy used to connect to InfluxDB, e.g.
+	// "http://proxy.example.com:3128".
+	// Default is the proxy of the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	// environment variables
+	HTTPProxy string
+
 	// Database to write points to.
 	// Default is "stats" and is auto created
 	Database string
 
+	// Do not auto create Database, e.g. when the user lacks admin privileges.
+	// The database must then exist before points are written.
+	SkipDatabaseCreation bool
+
+	// Collect and build points as usual but log the line protocol of every
+	// point with Logger instead of writing it. Neither an InfluxDB client nor
+	// the database is created, e.g. to check the shape of the metrics before
+	// writing to a shared InfluxDB.
+	// Default is false
+	DryRun bool
+
+	// Number of times the initial ping of InfluxDB is retried before
+	// RunCollector gives up, e.g. while InfluxDB is still starting.
+	// Default is 0
+	StartupRetries int
+
+	// Time to wait between the startup retries.
+	// Default is 1 second
+	StartupRetryInterval time.Duration
+
 	// Username with privileges on provided database.
 	Username string
 
 	// Password for provided user.
 	Password string
 
-	// Measurement to write points to.
+	// Measurement to write points to. It is rendered as a text/template when it
+	// contains "{{", e.g. "runtime.{{.Host}}.{{.Service}}", with the variables
+	// Host, PID, Goos, Goarch and MeasurementVars.
 	// Default is "go.runtime.<hostname>".
 	Measurement string
 
+	// Additional variables of the Measurement template, e.g. "Service". They
+	// take precedence over the built-in variables.
+	MeasurementVars map[string]string
+
 	// Measurement to write points to.
 	RetentionPolicy string
 
+	// Auto create RetentionPolicy on Database, after the database, with
+	// RetentionPolicyDuration and RetentionPolicyReplication. Failing to create
+	// it, e.g. because it exists with other settings, is logged with Logger and
+	// does not stop RunCollector.
+	// Default is false
+	CreateRetentionPolicy bool
+
+	// Duration the data of the created retention policy is kept, at least an
+	// hour. Ignored unless CreateRetentionPolicy is set.
+	// Default is 0, keeping the data forever
+	RetentionPolicyDuration time.Duration
+
+	// Number of copies of the data of the created retention policy kept on a
+	// cluster. Ignored unless CreateRetentionPolicy is set.
+	// Default is 1
+	RetentionPolicyReplication int
+
+	// Consistency level of the writes on a cluster, one of "any", "one",
+	// "quorum" or "all". Ignored by InfluxDB 2.x and standalone servers.
+	// Default is empty, the level configured by the server
+	WriteConsistency string
+
+	// Prefix prepended to every field key, e.g. "myapp.go." results in
+	// "myapp.go.mem.heap.alloc".
+	FieldPrefix string
+
+	// Maps every field key to the key written to InfluxDB. It is applied
+	// before FieldPrefix.
+	FieldKeyFunc func(string) string
+
+	// Unit of the byte-valued fields, one of "bytes", "kb", "mb" or "gb" (powers
+	// of 1024). Other than with "bytes" the byte-valued fields are divided by the
+	// unit and written as floats. They are the fields with the unit
+	// collector.UnitBytes or collector.UnitBytesPerSecond: mem.alloc, mem.total,
+	// mem.sys, mem.othersys, mem.alloc_rate, mem.heap.* other than
+	// mem.heap.objects, mem.heap.objects_rate and mem.heap.fragmentation,
+	// mem.stack.*, mem.gc.sys, mem.gc.next, mem.gc.heap_live and proc.rss.
+	// Default is "bytes"
+	ByteUnit string
+
+	// Number of decimal places float fields, such as mem.gc.cpu_fraction and
+	// the fields scaled by ByteUnit, are rounded to, reducing the storage of
+	// long decimals. Rounding to whole numbers is not supported.
+	// Default is 0, no rounding
+	FloatDecimals int
+
+	// Keys of the statistics written to InfluxDB, e.g. "cpu.goroutines". See
+	// collector.Collector.FieldFilter. RunCollector returns an error for
+	// unknown keys.
+	// Default writes all statistics
+	FieldFilter []string
+
+	// Transforms every set of statistics before it is turned into a point, e.g.
+	// to redact values or convert them to other units. Statistics the
+	// interceptor sets to zero are dropped from the point, statistics that were
+	// zero already are kept.
+	FieldsInterceptor func(collector.Fields) collector.Fields
+
+	// Compress the written batches with gzip, reducing the write bandwidth at
+	// the cost of some CPU time.
+	// Default is false
+	GzipEnabled bool
+
+	// Write to InfluxDB 2.x instead of 1.x when set. Host is used as the address
+	// while Database, Username, Password and RetentionPolicy are ignored.
+	V2 *V2Config
+
+	// Tags added to every point, e.g. service, env or region. A tag with the
+	// same key as one of the go.* tags replaces it.
+	Tags map[string]string
+
+	// Value of the "process" tag added to every point, so that the series of
+	// several processes on the same host are kept apart. Note that the PID
+	// changes with every restart, creating new series.
+	// Default is the PID of the process
+	ProcessTag string
+
+	// Do not add the "process" tag.
+	// Default is false
+	DisableProcessTag bool
+
 	// Interval at which to write batched points to InfluxDB.
 	// Default is 60 seconds
 	BatchInterval time.Duration
 
-	// Precision in time to write your points in.
-	// Default is nanoseconds
+	// Maximum duration of writing a batch, after which the write fails and the
+	// points are kept for the next batch interval. It only applies to the
+	// periodic writes, not to the ping on startup. With several hosts it
+	// applies to each host.
+	// Default is 0, limited only by the timeout of the client
+	WriteTimeout time.Duration
+
+	// Maximum number of points held in memory while waiting to be written, e.g.
+	// during an InfluxDB outage. When exceeded the oldest points are dropped and
+	// the number of dropped points is logged on the next batch interval.
+	// Default is 0, no limit.
+	MaxBatchPoints int
+
+	// Number of collected points that can be queued while a batch is written.
+	// Default is 64
+	PointBufferSize int
+
+	// What to do with collected points when the queue is full. Dropped points
+	// are logged on the next batch interval and counted by Runner.DroppedPoints.
+	// Default is DropNewest
+	Backpressure BackpressurePolicy
+
+	// Precision in time to write your points in, one of "ns", "us", "ms" or
+	// "s". Timestamps are truncated to it.
+	// Default is "ns"
 	Precision string
 
+	// Clock returns the timestamp of each collected point.
+	// Default is time.Now
+	Clock func() time.Time
+
+	// Derive the timestamps from the first timestamp plus the time elapsed on
+	// the monotonic clock, and keep every timestamp after the previous one at
+	// the given Precision. Points are then neither reordered nor overwritten
+	// when the wall clock is adjusted, e.g. by NTP, at the cost of drifting from
+	// it. The monotonic clock is only used with the default Clock.
+	// Default is false
+	MonotonicTimestamps bool
+
 	// Interval at which to collect points.
 	// Default is 10 seconds
 	CollectionInterval time.Duration
 
+	// Randomly shortens or lengthens each collection and batch interval by up
+	// to this duration to spread the load of many instances over time.
+	// Default is 0
+	IntervalJitter time.Duration
+
 	// Disable collecting CPU Statistics. cpu.*
 	// Default is false
 	DisableCpu bool
 
 	// Disable collecting Memory Statistics. mem.*
+	// It disables the GC statistics as well, which are part of them, and logs a
+	// warning with Logger unless DisableGc is set too.
 	DisableMem bool
 
 	// Disable collecting GC Statistics (requires Memory be not be disabled). mem.gc.*
 	DisableGc bool
 
-	// Default is DefaultLogger which exits when the library encounters a fatal error.
+	// Enable collecting the additional statistics provided by runtime/metrics.
+	// mem.gc.heap_live requires Go 1.21, sync.mutex_wait_ns Go 1.20 and
+	// sched.latency_* Go 1.17.
+	// Default is false
+	EnableRuntimeMetrics bool
+
+	// Enable collecting process statistics from /proc. proc.*
+	// Default is false
+	EnableProcess bool
+
+	// Enable computing the allocation, GC and heap object growth rates per
+	// second between two collections. mem.alloc_rate, mem.gc.rate,
+	// mem.heap.objects_rate
+	// Default is false
+	EnableDerived bool
+
+	// Enable collecting mutex and block profile totals, which copies the profiles
+	// on every collection. The profiles must be enabled with
+	// runtime.SetMutexProfileFraction and runtime.SetBlockProfileRate.
+	// sync.mutex_wait_total, sync.block_total
+	// Default is false
+	EnableContention bool
+
+	// Count goroutines by state. Parsing the stacks of all goroutines stops the
+	// world on every collection, see collector.Collector.EnableGoroutineStates.
+	// cpu.goroutines.running, cpu.goroutines.waiting, ...
+	// Default is false
+	EnableGoroutineStates bool
+
+	// Enable collecting the finalizer and cleanup queue totals and backlogs,
+	// which require Go 1.25. The cleanup values are approximations.
+	// mem.gc.finalizers.*, mem.gc.cleanups.*
+	// Default is false
+	EnableFinalizers bool
+
+	// Enable collecting the allocation and free counts of every size class,
+	// adding 120 fields to every point.
+	// mem.bysize.<size>.mallocs, mem.bysize.<size>.frees
+	// Default is false
+	EnableSizeClasses bool
+
+	// Add the module version and VCS revision and time of the main module as
+	// tags when known. build.version, build.revision, build.time
+	// Default is false
+	EnableBuildInfo bool
+
+	// Add the start time of the process in seconds since the Unix epoch as a tag
+	// to tell runs of the process apart, see collector.Collector.EnableStartEpoch
+	// for computing rates of counters across restarts. proc.start_epoch
+	// Default is false
+	EnableStartEpoch bool
+
+	// Interval at which the goroutine count is sampled in between collections
+	// to output the highest count since the previous collection, see
+	// collector.Collector.GoroutinesMaxInterval. cpu.goroutines.max
+	// Default is 0, disabled
+	GoroutinesMaxInterval time.Duration
+
+	// Publish the statistics of the latest collection under this expvar name,
+	// e.g. in /debug/vars, formatted like expvar.Publish of this module. The
+	// variable is fed by the same collection as the points, so the statistics
+	// are not read twice. A Runner started again with the same name takes over
+	// the variable. RunCollector returns an error if the name is registered by
+	// other code.
+	// Default is empty, not published
+	ExpvarName string
+
+	// Default is StdLogger which logs both levels with the standard log package.
+	// Use DefaultLogger to exit when the library encounters a fatal error.
 	Logger Logger
+
+	// OnError is called with errors that occur while writing points to InfluxDB.
+	// These errors are not fatal, the points are kept and retried on the next
+	// batch interval.
+	// Default logs the error with Logger.Println.
+	OnError func(error)
+
+	// OnFlush is called after every write of a batch, including the final one on
+	// Stop, with the number of points, the duration of the write and its error,
+	// which is nil when the points landed. It is called on the goroutine writing
+	// the batches and must return quickly.
+	// Default is nil
+	OnFlush func(points int, duration time.Duration, err error)
 }
 
 func (config *Config) init() (*Config, error) {
@@ -84,16 +402,135 @@ func (config *Config) init() (*Config, error) {
 		config.Host = defaultHost
 	}
 
+	if config.ProcessTag == "" {
+		config.ProcessTag = strconv.Itoa(os.Getpid())
+	}
+
 	if config.Measurement == "" {
-		config.Measurement = defaultMeasurement
+		config.Measurement = defaultMeasurement + "." + hostname()
+	}
 
-		if hn, err := os.Hostname(); err != nil {
-			config.Measurement += ".unknown"
-		} else {
-			config.Measurement += "." + hn
+	// Collect every problem, so that a misconfiguration is fixed in one go.
+	var problems []string
+	invalid := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if measurement, err := config.measurement(); err != nil {
+		invalid("%v", err)
+	} else {
+		config.Measurement = measurement
+	}
+
+	if config.Precision == "" {
+		config.Precision = "ns"
+	}
+
+	if _, ok := precisions[config.Precision]; !ok {
+		invalid("invalid precision %q, must be one of ns, us, ms or s", config.Precision)
+	}
+
+	if config.ByteUnit == "" {
+		config.ByteUnit = "bytes"
+	}
+
+	if _, ok := byteUnits[config.ByteUnit]; !ok {
+		invalid("invalid byte unit %q, must be one of bytes, kb, mb or gb", config.ByteUnit)
+	}
+
+	if config.CreateRetentionPolicy {
+		if config.RetentionPolicy == "" {
+			invalid("CreateRetentionPolicy requires a RetentionPolicy")
+		}
+
+		if config.RetentionPolicyDuration != 0 && config.RetentionPolicyDuration < time.Hour {
+			invalid("invalid retention policy duration %v, must be 0 or at least 1h", config.RetentionPolicyDuration)
+		}
+
+		if config.RetentionPolicyReplication == 0 {
+			config.RetentionPolicyReplication = 1
+		}
+
+		if config.RetentionPolicyReplication < 0 {
+			invalid("invalid retention policy replication %d, must be positive", config.RetentionPolicyReplication)
+		}
+	}
+
+	switch config.WriteConsistency {
+	case "", "any", "one", "quorum", "all":
+	default:
+		invalid("invalid write consistency %q, must be one of any, one, quorum or all", config.WriteConsistency)
+	}
+
+	if len(config.FieldFilter) > 0 {
+		c := collector.New()
+		c.FieldFilter = config.FieldFilter
+
+		if err := c.Validate(); err != nil {
+			invalid("%v", err)
+		}
+	}
+
+	useTLS := config.TLSConfig != nil || config.TLSCertFile != "" || config.TLSKeyFile != "" || config.TLSCAFile != ""
+
+	if config.UseUDP && useTLS {
+		invalid("UseUDP cannot be combined with TLS, UDP writes are not encrypted")
+	}
+
+	if config.UseUDP && config.V2 != nil {
+		invalid("UseUDP cannot be combined with V2, InfluxDB 2.x does not accept UDP writes")
+	}
+
+	if config.SocketPath != "" && (config.UseUDP || config.V2 != nil) {
+		invalid("SocketPath cannot be combined with UseUDP or V2")
+	}
+
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		invalid("TLSCertFile and TLSKeyFile must be set together")
+	}
+
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"CollectionInterval", config.CollectionInterval},
+		{"BatchInterval", config.BatchInterval},
+		{"IntervalJitter", config.IntervalJitter},
+		{"WriteTimeout", config.WriteTimeout},
+		{"StartupRetryInterval", config.StartupRetryInterval},
+	} {
+		if d.value < 0 {
+			invalid("invalid %s %v, must not be negative", d.name, d.value)
 		}
 	}
 
+	for _, n := range []struct {
+		name  string
+		value int
+	}{
+		{"StartupRetries", config.StartupRetries},
+		{"MaxBatchPoints", config.MaxBatchPoints},
+		{"FloatDecimals", config.FloatDecimals},
+	} {
+		if n.value < 0 {
+			invalid("invalid %s %d, must not be negative", n.name, n.value)
+		}
+	}
+
+	switch config.Backpressure {
+	case DropNewest, DropOldest, Block:
+	default:
+		invalid("invalid backpressure policy %d", config.Backpressure)
+	}
+
+	if config.ExpvarName != "" && !expvarAvailable(config.ExpvarName) {
+		invalid("expvar %q is already registered", config.ExpvarName)
+	}
+
+	if len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
+	}
+
 	if config.CollectionInterval == 0 {
 		config.CollectionInterval = defaultCollectionInterval
 	}
@@ -102,92 +539,724 @@ func (config *Config) init() (*Config, error) {
 		config.BatchInterval = defaultBatchInterval
 	}
 
+	if config.StartupRetryInterval <= 0 {
+		config.StartupRetryInterval = defaultStartupRetryWait
+	}
+
+	if config.PayloadSize <= 0 {
+		config.PayloadSize = client.UDPPayloadSize
+	}
+
+	if config.PointBufferSize <= 0 {
+		config.PointBufferSize = defaultPointBufferSize
+	}
+
+	if config.Clock == nil {
+		config.Clock = time.Now
+	}
+
 	if config.Logger == nil {
-		config.Logger = &DefaultLogger{}
+		config.Logger = &StdLogger{}
+	}
+
+	if config.OnError == nil {
+		logger := config.Logger
+		config.OnError = func(err error) {
+			logger.Println(err)
+		}
+	}
+
+	if config.DisableMem && !config.DisableGc {
+		config.Logger.Println("DisableMem disables the GC statistics too, set DisableGc to silence this warning")
+		config.DisableGc = true
 	}
 
 	return config, nil
 }
 
-func RunCollector(config *Config) (err error) {
+// Runner is a handle to the collection started by RunCollector. It owns the
+// goroutines collecting and writing statistics.
+type Runner struct {
+	stats *runStats
+	done  chan struct{}
+	wg    sync.WaitGroup
+
+	stopOnce sync.Once
+	stopErr  error
+
+	// Closed once stopped, if set
+	closer io.Closer
+}
+
+func (r *Runner) start(c *collector.Collector) {
+	r.wg.Add(2)
+
+	go func() {
+		defer r.wg.Done()
+		r.stats.loop(r.stats.config.BatchInterval)
+	}()
+
+	go func() {
+		defer r.wg.Done()
+		c.Run()
+	}()
+}
+
+// Stop stops collecting statistics and writes any points that have not been
+// written yet. It returns once all goroutines started by RunCollector have
+// exited. The returned error reports a failure of that final write, in which
+// case the pending points are lost. Subsequent calls return the same error.
+func (r *Runner) Stop() error {
+	r.stopOnce.Do(func() {
+		close(r.done)
+		r.stopErr = <-r.stats.stopped
+		r.wg.Wait()
+
+		if r.closer != nil {
+			r.closer.Close()
+		}
+	})
+
+	return r.stopErr
+}
+
+// DroppedPoints returns the number of collected points that were dropped
+// because of MaxBatchPoints or the Backpressure policy.
+func (r *Runner) DroppedPoints() int64 {
+	return atomic.LoadInt64(&r.stats.totalDropped)
+}
+
+// Wait blocks until the Runner has been stopped and all goroutines started by
+// RunCollector have exited.
+func (r *Runner) Wait() {
+	<-r.done
+	r.wg.Wait()
+}
+
+// RunCollector starts collecting statistics and writing them to InfluxDB in
+// the background. Call Stop on the returned Runner to stop, no goroutine
+// started by RunCollector is left running once Stop returns. An invalid config
+// is rejected with a *ConfigError before connecting to InfluxDB.
+func RunCollector(config *Config) (*Runner, error) {
+	return RunCollectorContext(context.Background(), config)
+}
+
+// RunCollectorContext behaves like RunCollector but gives up connecting to
+// InfluxDB, including the startup retries, once ctx is done. ctx does not
+// affect the Runner once it has been started.
+func RunCollectorContext(ctx context.Context, config *Config) (_ *Runner, err error) {
 	if config, err = config.init(); err != nil {
-		return err
+		return nil, err
 	}
 
-	// Make client
-	clnt, err := client.NewHTTPClient(client.HTTPConfig{
-		Addr:     "http://" + config.Host,
-		Username: config.Username,
-		Password: config.Password,
-	})
+	writer, closer, err := openWriter(ctx, config)
 
 	if err != nil {
-		return errors.Wrap(err, "failed to create influxdb client")
+		return nil, err
 	}
 
-	// Ping InfluxDB to ensure there is a connection
-	if _, _, err := clnt.Ping(5 * time.Second); err != nil {
-		return errors.Wrap(err, "failed to ping influxdb client")
+	runner, err := startRunner(config, writer)
+
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, err
+	}
+
+	runner.closer = closer
+	return runner, nil
+}
+
+// RunOnce collects a single set of statistics and writes it to InfluxDB before
+// returning, e.g. for cron jobs and other short-lived processes which might
+// exit before the first BatchInterval. It connects like RunCollector, the
+// database is created unless SkipDatabaseCreation is set, and returns the
+// error of the write. Unlike RunCollector it leaves no goroutines running and
+// ignores the settings of the background collection, such as ExpvarName.
+func RunOnce(config *Config) (err error) {
+	if config, err = config.init(); err != nil {
+		return err
 	}
 
-	// Auto create database
-	_, err = queryDB(clnt, fmt.Sprintf("CREATE DATABASE \"%s\"", config.Database))
+	writer, closer, err := openWriter(context.Background(), config)
 
 	if err != nil {
-		config.Logger.Fatalln(err)
+		return err
+	}
+
+	if closer != nil {
+		defer closer.Close()
+	}
+
+	if config.WriteTimeout > 0 {
+		writer = withWriteTimeout(writer, config.WriteTimeout)
+	}
+
+	r := &runStats{logger: config.Logger, client: writer, config: config}
+
+	if r.points, err = r.newBatch(); err != nil {
+		return err
+	}
+
+	pt, err := r.newPoint(newCollector(config).OneOff())
+
+	if err != nil || pt == nil {
+		return err
+	}
+
+	r.addPoint(pt)
+	return r.flush()
+}
+
+// Create the writer of the points configured by config, connecting to every
+// host. closer is set when the writer must be closed once it is not used
+// anymore, it closes the clients of every host.
+func openWriter(ctx context.Context, config *Config) (writer batchWriter, closer io.Closer, err error) {
+	if config.DryRun {
+		return &dryRunWriter{logger: config.Logger}, nil, nil
+	}
+
+	if config.SocketPath != "" {
+		w, err := connectSocket(ctx, config)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return w, w, nil
+	}
+
+	hosts := append([]string{config.Host}, config.Hosts...)
+	writers := make(multiWriter, 0, len(hosts))
+	var closers multiCloser
+
+	for _, host := range hosts {
+		var writer batchWriter
+
+		if config.V2 != nil {
+			writer, err = connectV2(ctx, config, host)
+		} else if config.UseUDP {
+			writer, err = connectUDP(config, host)
+		} else {
+			writer, err = connect(ctx, config, host)
+		}
+
+		if err != nil {
+			closers.Close()
+			return nil, nil, errors.Wrapf(err, "host %s", host)
+		}
+
+		if c, ok := writer.(io.Closer); ok {
+			closers = append(closers, c)
+		}
+
+		writers = append(writers, hostWriter{host: host, writer: writer, onError: config.OnError})
+	}
+
+	if len(closers) > 0 {
+		closer = closers
+	}
+
+	if len(writers) == 1 {
+		return writers[0].writer, closer, nil
+	}
+
+	return writers, closer, nil
+}
+
+// RunCollectorWithClient behaves like RunCollector but writes to InfluxDB 1.x
+// through clnt instead of creating a HTTP client from config. It allows
+// providing a custom client, such as a fake recording batches in tests. clnt
+// is not used with DryRun.
+func RunCollectorWithClient(config *Config, clnt Client) (_ *Runner, err error) {
+	if config, err = config.init(); err != nil {
+		return nil, err
+	}
+
+	if config.DryRun {
+		return startRunner(config, &dryRunWriter{logger: config.Logger})
+	}
+
+	if err = prepare(context.Background(), config, clnt); err != nil {
+		return nil, err
 	}
 
+	return startRunner(config, clnt)
+}
+
+// Start collecting statistics and writing points with writer
+func startRunner(config *Config, writer batchWriter) (*Runner, error) {
+	if config.WriteTimeout > 0 {
+		writer = withWriteTimeout(writer, config.WriteTimeout)
+	}
+
+	done := make(chan struct{})
+
 	_runStats := &runStats{
-		logger: config.Logger,
-		client: clnt,
-		config: config,
-		pc:     make(chan *client.Point),
+		logger:  config.Logger,
+		client:  writer,
+		config:  config,
+		pc:      make(chan *client.Point, config.PointBufferSize),
+		done:    done,
+		stopped: make(chan error, 1),
 	}
 
 	bp, err := _runStats.newBatch()
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	_runStats.points = bp
 
-	go _runStats.loop(config.BatchInterval)
+	_collector := newCollector(config)
+	_collector.AddSink(_runStats.onNewPoint)
+
+	if config.ExpvarName != "" {
+		v := influxdb.NewLatestVar(config.Measurement)
+		publishExpvar(config.ExpvarName, v)
+		_collector.AddSink(v.Set)
+	}
+
+	_collector.Done = done
 
-	_collector := collector.New(_runStats.onNewPoint)
+	runner := &Runner{stats: _runStats, done: done}
+	runner.start(_collector)
+
+	return runner, nil
+}
+
+// The expvars published for ExpvarName. expvar cannot unpublish a variable, so
+// a Runner started again with the same name rebinds the published one.
+var expvars = struct {
+	sync.Mutex
+	vars map[string]*runnerVar
+}{vars: make(map[string]*runnerVar)}
+
+// An expvar.Var formatting the LatestVar of the last Runner started with its
+// name
+type runnerVar struct {
+	mu sync.Mutex
+	v  *influxdb.LatestVar
+}
+
+func (rv *runnerVar) String() string {
+	rv.mu.Lock()
+	v := rv.v
+	rv.mu.Unlock()
+
+	return v.String()
+}
+
+// Whether name is unused or published by a Runner
+func expvarAvailable(name string) bool {
+	expvars.Lock()
+	defer expvars.Unlock()
+
+	return expvars.vars[name] != nil || expvar.Get(name) == nil
+}
+
+// Publish v under name, replacing the LatestVar of a previous Runner
+func publishExpvar(name string, v *influxdb.LatestVar) {
+	expvars.Lock()
+	defer expvars.Unlock()
+
+	if rv := expvars.vars[name]; rv != nil {
+		rv.mu.Lock()
+		rv.v = v
+		rv.mu.Unlock()
+		return
+	}
+
+	rv := &runnerVar{v: v}
+	expvar.Publish(name, rv)
+	expvars.vars[name] = rv
+}
+
+// Create a collector configured by config without sinks
+func newCollector(config *Config) *collector.Collector {
+	_collector := collector.New()
 	_collector.PauseDur = config.CollectionInterval
+	_collector.Jitter = config.IntervalJitter
 	_collector.EnableCPU = !config.DisableCpu
 	_collector.EnableMem = !config.DisableMem
 	_collector.EnableGC = !config.DisableGc
+	_collector.EnableRuntimeMetrics = config.EnableRuntimeMetrics
+	_collector.EnableProcess = config.EnableProcess
+	_collector.EnableContention = config.EnableContention
+	_collector.EnableGoroutineStates = config.EnableGoroutineStates
+	_collector.EnableFinalizers = config.EnableFinalizers
+	_collector.EnableSizeClasses = config.EnableSizeClasses
+	_collector.EnableBuildInfo = config.EnableBuildInfo
+	_collector.EnableStartEpoch = config.EnableStartEpoch
+	_collector.GoroutinesMaxInterval = config.GoroutinesMaxInterval
+	_collector.EnableDerived = config.EnableDerived
+	_collector.FieldFilter = config.FieldFilter
+
+	return _collector
+}
+
+// batchWriter writes a batch of points to InfluxDB. It is satisfied by
+// client.Client.
+type batchWriter interface {
+	Write(bp client.BatchPoints) error
+}
 
-	go _collector.Run()
+// Logs the points of every batch instead of writing them, see Config.DryRun
+type dryRunWriter struct {
+	logger Logger
+}
+
+func (w *dryRunWriter) Write(bp client.BatchPoints) error {
+	for _, pt := range bp.Points() {
+		w.logger.Println(pt.String())
+	}
 
 	return nil
 }
 
+// Client is the subset of the InfluxDB 1.x client.Client used by this package.
+type Client interface {
+	Ping(timeout time.Duration) (time.Duration, string, error)
+	Write(bp client.BatchPoints) error
+	Query(q client.Query) (*client.Response, error)
+}
+
+// Connect to the InfluxDB 1.x host and create the database
+func connect(ctx context.Context, config *Config, host string) (client.Client, error) {
+	tlsConfig, err := config.tlsConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := config.proxy()
+
+	if err != nil {
+		return nil, err
+	}
+
+	httpConfig := client.HTTPConfig{
+		Addr:      addr(host, tlsConfig),
+		Username:  config.Username,
+		Password:  config.Password,
+		TLSConfig: tlsConfig,
+		Proxy:     proxy,
+	}
+
+	clnt, err := client.NewHTTPClient(httpConfig)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create influxdb client")
+	}
+
+	if config.GzipEnabled {
+		clnt = newGzipClient(clnt, httpConfig.Addr, config, tlsConfig, proxy)
+	}
+
+	if err = prepare(ctx, config, clnt); err != nil {
+		clnt.Close()
+		return nil, err
+	}
+
+	return clnt, nil
+}
+
+// Ensure InfluxDB is reachable and create the database
+func prepare(ctx context.Context, config *Config, clnt Client) error {
+	// Ping InfluxDB to ensure there is a connection
+	err := pingWithRetries(ctx, config, func(ctx context.Context) error {
+		timeout := pingTimeout
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline)
+		}
+
+		_, _, err := clnt.Ping(timeout)
+		return err
+	})
+
+	if err != nil {
+		return classify(ErrPingFailed, errors.Wrap(err, "failed to ping influxdb client"))
+	}
+
+	if !config.SkipDatabaseCreation {
+		// Auto create database
+		_, err = queryDB(clnt, fmt.Sprintf("CREATE DATABASE \"%s\"", config.Database))
+
+		if err != nil {
+			return classify(nil, errors.Wrap(err, "failed to create database"))
+		}
+	}
+
+	if config.CreateRetentionPolicy {
+		createRetentionPolicy(config, clnt)
+	}
+
+	return nil
+}
+
+// Create the retention policy, logging failures as points can still be
+// written if it exists.
+func createRetentionPolicy(config *Config, clnt Client) {
+	duration := "INF"
+	if config.RetentionPolicyDuration > 0 {
+		duration = fmt.Sprintf("%ds", int64(config.RetentionPolicyDuration/time.Second))
+	}
+
+	_, err := queryDB(clnt, fmt.Sprintf("CREATE RETENTION POLICY \"%s\" ON \"%s\" DURATION %s REPLICATION %d",
+		config.RetentionPolicy, config.Database, duration, config.RetentionPolicyReplication))
+
+	if err != nil {
+		config.Logger.Println(errors.Wrapf(err, "failed to create retention policy %q", config.RetentionPolicy))
+	}
+}
+
+// Call ping until it succeeds, StartupRetries are exhausted or ctx is done.
+// Every attempt is limited to pingTimeout.
+func pingWithRetries(ctx context.Context, config *Config, ping func(context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+		err := ping(pingCtx)
+		cancel()
+
+		if err == nil || attempt >= config.StartupRetries {
+			return err
+		}
+
+		config.Logger.Println(fmt.Sprintf("influxdb is not reachable, retrying in %v: %v", config.StartupRetryInterval, err))
+
+		timer := time.NewTimer(config.StartupRetryInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
 type runStats struct {
 	logger Logger
-	client client.Client
+	client batchWriter
 	points client.BatchPoints
 	config *Config
 	pc     chan *client.Point
+
+	// Number of points dropped since the last batch interval and since the
+	// start, either because pc was full or MaxBatchPoints was exceeded.
+	dropped      int64
+	totalDropped int64
+
+	// done is closed to stop the loop, which then sends the result of the
+	// final flush on stopped.
+	done    <-chan struct{}
+	stopped chan error
+
+	// First and previous timestamp with MonotonicTimestamps
+	start, last time.Time
 }
 
 func (r *runStats) onNewPoint(fields collector.Fields) {
-	pt, err := client.NewPoint(r.config.Measurement, fields.Tags(), fields.Values(), time.Now())
+	pt, err := r.newPoint(fields)
+
+	if err != nil {
+		r.logger.Fatalln(err)
+		return
+	}
+
+	if pt == nil {
+		return
+	}
+
+	switch r.config.Backpressure {
+	case Block:
+		select {
+		case r.pc <- pt:
+		case <-r.done:
+		}
+
+	case DropOldest:
+		for {
+			select {
+			case r.pc <- pt:
+				return
+			case <-r.done:
+				return
+			default:
+			}
+
+			select {
+			case <-r.pc:
+				r.drop(1)
+			default:
+			}
+		}
+
+	default:
+		select {
+		case r.pc <- pt:
+		case <-r.done:
+		default:
+			r.drop(1)
+		}
+	}
+}
+
+// Create the point of fields, nil when FieldsInterceptor dropped every value
+func (r *runStats) newPoint(fields collector.Fields) (*client.Point, error) {
+	var values map[string]interface{}
+	if r.config.FieldsInterceptor != nil {
+		fields, values = r.intercept(fields)
+		if len(values) == 0 {
+			return nil, nil
+		}
+	} else {
+		values = fields.Values()
+	}
+
+	tags := fields.Tags()
+	if !r.config.DisableProcessTag {
+		tags[processTagKey] = r.config.ProcessTag
+	}
+	for k, v := range r.config.Tags {
+		tags[k] = v
+	}
+
+	if r.config.ByteUnit != "bytes" {
+		scaleBytes(values, byteUnits[r.config.ByteUnit])
+	}
+
+	if r.config.FloatDecimals > 0 {
+		roundFloats(values, r.config.FloatDecimals)
+	}
+
+	if r.config.FieldKeyFunc != nil || r.config.FieldPrefix != "" {
+		values = r.renameFields(values)
+	}
+
+	t := r.timestamp()
+	pt, err := client.NewPoint(r.config.Measurement, tags, values, t)
 
 	if err != nil {
-		r.logger.Fatalln(errors.Wrap(err, "error while creating point"))
+		return nil, errors.Wrap(err, "error while creating point")
+	}
+
+	return pt, nil
+}
+
+func (r *runStats) drop(n int64) {
+	atomic.AddInt64(&r.dropped, n)
+	atomic.AddInt64(&r.totalDropped, n)
+}
+
+// Timestamp of a new point truncated to Precision
+func (r *runStats) timestamp() time.Time {
+	precision := precisions[r.config.Precision]
+	t := r.config.Clock()
+
+	if !r.config.MonotonicTimestamps {
+		return t.Truncate(precision)
 	}
 
-	r.pc <- pt
+	if r.start.IsZero() {
+		r.start = t
+	}
+
+	// Sub uses the monotonic clock readings of time.Now
+	t = r.start.Add(t.Sub(r.start)).Truncate(precision)
+	if !r.last.IsZero() && !t.After(r.last) {
+		t = r.last.Add(precision)
+	}
+	r.last = t
+
+	return t
+}
+
+// Apply FieldsInterceptor to fields and drop the values it set to zero
+func (r *runStats) intercept(fields collector.Fields) (collector.Fields, map[string]interface{}) {
+	before := fields.Values()
+
+	fields = r.config.FieldsInterceptor(fields)
+	values := fields.Values()
+
+	for key, value := range values {
+		if isZero(value) && !isZero(before[key]) {
+			delete(values, key)
+		}
+	}
+
+	return fields, values
+}
+
+func isZero(value interface{}) bool {
+	switch v := value.(type) {
+	case int64:
+		return v == 0
+	case float64:
+		return v == 0
+	case bool:
+		return !v
+	case string:
+		return v == ""
+	}
+	return false
+}
+
+// Apply FieldKeyFunc and FieldPrefix to the keys of values
+func (r *runStats) renameFields(values map[string]interface{}) map[string]interface{} {
+	renamed := make(map[string]interface{}, len(values))
+
+	for key, value := range values {
+		if r.config.FieldKeyFunc != nil {
+			key = r.config.FieldKeyFunc(key)
+		}
+
+		renamed[r.config.FieldPrefix+key] = value
+	}
+
+	return renamed
+}
+
+// Round the float statistics to decimals decimal places
+func roundFloats(values map[string]interface{}, decimals int) {
+	scale := math.Pow10(decimals)
+
+	for key, value := range values {
+		if v, ok := value.(float64); ok && !math.IsInf(v*scale, 0) {
+			values[key] = math.Round(v*scale) / scale
+		}
+	}
+}
+
+// Divide the byte-valued statistics by unit, see Config.ByteUnit
+func scaleBytes(values map[string]interface{}, unit float64) {
+	for key, value := range values {
+		meta, ok := collector.LookupFieldMeta(key)
+
+		if !ok || (meta.Unit != collector.UnitBytes && meta.Unit != collector.UnitBytesPerSecond) {
+			continue
+		}
+
+		switch v := value.(type) {
+		case int64:
+			values[key] = float64(v) / unit
+		case float64:
+			values[key] = v / unit
+		}
+	}
 }
 
 func (r *runStats) newBatch() (bp client.BatchPoints, err error) {
 	bp, err = client.NewBatchPoints(client.BatchPointsConfig{
-		Database:        r.config.Database,
-		Precision:       r.config.Precision,
-		RetentionPolicy: r.config.RetentionPolicy,
+		Database:         r.config.Database,
+		Precision:        r.config.Precision,
+		RetentionPolicy:  r.config.RetentionPolicy,
+		WriteConsistency: r.config.WriteConsistency,
 	})
 
 	if err != nil {
@@ -197,39 +1266,114 @@ func (r *runStats) newBatch() (bp client.BatchPoints, err error) {
 	return
 }
 
+// Write the pending points to influxdb and start a new batch
+func (r *runStats) flush() error {
+	if r.points == nil || len(r.points.Points()) <= 0 {
+		return nil
+	}
+
+	start := time.Now()
+	err := r.client.Write(r.points)
+	elapsed := time.Since(start)
+
+	if err != nil {
+		if r.config.SkipDatabaseCreation && strings.Contains(err.Error(), "database not found") {
+			err = classify(ErrWriteFailed, errors.Wrapf(err, "database %q does not exist and SkipDatabaseCreation is set", r.config.Database))
+		} else {
+			err = classify(ErrWriteFailed, errors.Wrap(err, "could not write points to InfluxDB"))
+		}
+	}
+
+	if r.config.OnFlush != nil {
+		r.config.OnFlush(len(r.points.Points()), elapsed, err)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	r.points = nil
+
+	bp, err := r.newBatch()
+
+	if err != nil {
+		return errors.Wrap(err, "could not create BatchPoints")
+	}
+
+	r.points = bp
+
+	return nil
+}
+
+// Add a point to the pending batch, dropping the oldest points when the batch
+// would exceed MaxBatchPoints
+func (r *runStats) addPoint(pt *client.Point) {
+	limit := r.config.MaxBatchPoints
+	points := r.points.Points()
+
+	if limit <= 0 || len(points) < limit {
+		r.points.AddPoint(pt)
+		return
+	}
+
+	bp, err := r.newBatch()
+
+	if err != nil {
+		return
+	}
+
+	excess := len(points) - limit + 1
+	bp.AddPoints(points[excess:])
+	bp.AddPoint(pt)
+
+	r.points = bp
+	r.drop(int64(excess))
+}
+
 // Write collected points to influxdb periodically
 func (r *runStats) loop(interval time.Duration) {
-	ticks := time.Tick(interval)
+	ticker := time.NewTicker(collector.Jittered(interval, r.config.IntervalJitter))
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticks:
-			if r.points == nil || len(r.points.Points()) <= 0 {
-				continue
-			}
+		case <-r.done:
+			r.drain()
+			r.stopped <- r.flush()
+			return
 
-			if err := r.client.Write(r.points); err != nil {
-				r.logger.Fatalln(errors.Wrap(err, "could not write points to InfluxDB"))
-				continue
+		case <-ticker.C:
+			if r.config.IntervalJitter > 0 {
+				ticker.Reset(collector.Jittered(interval, r.config.IntervalJitter))
 			}
 
-			r.points = nil
-
-			bp, err := r.newBatch()
-
-			if err != nil {
-				r.logger.Fatalln(errors.Wrap(err, "could not create BatchPoints"))
-				continue
+			if dropped := atomic.SwapInt64(&r.dropped, 0); dropped > 0 {
+				r.logger.Println(fmt.Sprintf("dropped %d points", dropped))
 			}
 
-			r.points = bp
+			if err := r.flush(); err != nil {
+				r.config.OnError(err)
+			}
 
 		case pt := <-r.pc:
 			if r.points != nil {
-				r.logger.Println(pt.String())
+				r.addPoint(pt)
+			}
+		}
+	}
+}
 
-				r.points.AddPoint(pt)
+// Add the points still queued in pc to the batch, so that the final flush
+// writes them
+func (r *runStats) drain() {
+	for {
+		select {
+		case pt := <-r.pc:
+			if r.points != nil {
+				r.addPoint(pt)
 			}
+		default:
+			return
 		}
 	}
 }
@@ -239,12 +1383,19 @@ type Logger interface {
 	Fatalln(v ...interface{})
 }
 
+// DefaultLogger discards messages and exits the process on fatal errors.
 type DefaultLogger struct{}
 
 func (*DefaultLogger) Println(v ...interface{}) {}
-func (*DefaultLogger) Fatalln(v ...interface{}) { log.Fatalln(v) }
+func (*DefaultLogger) Fatalln(v ...interface{}) { log.Fatalln(v...) }
+
+// StdLogger logs messages of both levels with log.Println and never exits.
+type StdLogger struct{}
+
+func (*StdLogger) Println(v ...interface{}) { log.Println(v...) }
+func (*StdLogger) Fatalln(v ...interface{}) { log.Println(v...) }
 
-func queryDB(clnt client.Client, cmd string) (res []client.Result, err error) {
+func queryDB(clnt Client, cmd string) (res []client.Result, err error) {
 	q := client.Query{
 		Command: cmd,
 	}
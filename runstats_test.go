@@ -0,0 +1,1314 @@
+package runstats
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+// fakeClient records the batches and queries it receives.
+type fakeClient struct {
+	mu       sync.Mutex
+	batches  []client.BatchPoints
+	queries  []string
+	writeErr error
+	queryErr error
+
+	// Number of pings failing before the first successful one
+	pingFailures int
+	pings        int
+}
+
+func (f *fakeClient) Ping(timeout time.Duration) (time.Duration, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pings++
+	if f.pings <= f.pingFailures {
+		return 0, "", errors.New("connection refused")
+	}
+
+	return 0, "fake", nil
+}
+
+func (f *fakeClient) Write(bp client.BatchPoints) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.writeErr != nil {
+		return f.writeErr
+	}
+
+	f.batches = append(f.batches, bp)
+	return nil
+}
+
+func (f *fakeClient) Query(q client.Query) (*client.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.queries = append(f.queries, q.Command)
+	if f.queryErr != nil {
+		return nil, f.queryErr
+	}
+	return &client.Response{}, nil
+}
+
+func (f *fakeClient) points() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := 0
+	for _, bp := range f.batches {
+		n += len(bp.Points())
+	}
+	return n
+}
+
+func TestRunCollectorWithClient(t *testing.T) {
+	fake := &fakeClient{}
+
+	runner, err := RunCollectorWithClient(&Config{
+		Database:           "test",
+		Measurement:        "test",
+		CollectionInterval: 10 * time.Millisecond,
+		BatchInterval:      50 * time.Millisecond,
+	}, fake)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.queries) != 1 || !strings.Contains(fake.queries[0], `CREATE DATABASE "test"`) {
+		t.Errorf("expected database to be created, got queries %v", fake.queries)
+	}
+
+	if len(fake.batches) < 2 {
+		t.Errorf("expected several batches to be written, got %d", len(fake.batches))
+	}
+
+	for _, bp := range fake.batches {
+		if bp.Database() != "test" {
+			t.Errorf("expected database (test) got (%s)", bp.Database())
+		}
+		for _, pt := range bp.Points() {
+			if pt.Name() != "test" {
+				t.Errorf("expected measurement (test) got (%s)", pt.Name())
+			}
+			if pid := strconv.Itoa(os.Getpid()); pt.Tags()["process"] != pid {
+				t.Errorf("expected process tag (%s) got (%s)", pid, pt.Tags()["process"])
+			}
+		}
+	}
+}
+
+func TestStopFlushesPendingPoints(t *testing.T) {
+	fake := &fakeClient{}
+
+	runner, err := RunCollectorWithClient(&Config{
+		CollectionInterval: 10 * time.Millisecond,
+		BatchInterval:      time.Hour,
+	}, fake)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := fake.points(); n == 0 {
+		t.Errorf("expected pending points to be written on stop")
+	}
+}
+
+func TestStopWritesQueuedPoints(t *testing.T) {
+	config, _ := (&Config{BatchInterval: time.Hour}).init()
+	fake := &fakeClient{}
+	done := make(chan struct{})
+
+	r := &runStats{
+		config:  config,
+		logger:  config.Logger,
+		client:  fake,
+		pc:      make(chan *client.Point, 8),
+		done:    done,
+		stopped: make(chan error, 1),
+	}
+	r.points, _ = r.newBatch()
+
+	for i := 0; i < 5; i++ {
+		pt, _ := client.NewPoint("test", nil, map[string]interface{}{"value": i})
+		r.pc <- pt
+	}
+	close(done)
+
+	r.loop(config.BatchInterval)
+
+	if err := <-r.stopped; err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.points(); n != 5 {
+		t.Errorf("expected the 5 queued points to be written on stop, got %d", n)
+	}
+}
+
+func TestStopDoesNotLeakGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 10; i++ {
+		runner, err := RunCollectorWithClient(&Config{
+			CollectionInterval: time.Millisecond,
+			BatchInterval:      2 * time.Millisecond,
+		}, &fakeClient{})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+
+		if err := runner.Stop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("expected no leaked goroutines, got %d before and %d after", before, after)
+	}
+}
+
+func TestExpvarName(t *testing.T) {
+	fake := &fakeClient{}
+
+	runner, err := RunCollectorWithClient(&Config{
+		ExpvarName:         "runstats_test",
+		Measurement:        "test",
+		CollectionInterval: time.Hour,
+		BatchInterval:      time.Hour,
+	}, fake)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	v := expvar.Get("runstats_test")
+	if v == nil {
+		t.Fatal("expected the expvar to be published")
+	}
+
+	var point struct {
+		Name   string
+		Values map[string]interface{}
+	}
+	if err := json.Unmarshal([]byte(v.String()), &point); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+
+	if point.Name != "test" || point.Values["cpu.goroutines"] == nil {
+		t.Errorf("unexpected point %+v", point)
+	}
+
+	pt := fake.batches[0].Points()[0]
+	if fields, _ := pt.Fields(); fields["mem.gc.count"] != int64(point.Values["mem.gc.count"].(float64)) {
+		t.Errorf("expected the expvar to hold the written statistics, got %v and %v", fields["mem.gc.count"], point.Values["mem.gc.count"])
+	}
+
+	// A restarted Runner takes over the variable
+	runner, err = RunCollectorWithClient(&Config{
+		ExpvarName:         "runstats_test",
+		Measurement:        "restarted",
+		CollectionInterval: time.Hour,
+		BatchInterval:      time.Hour,
+	}, &fakeClient{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal([]byte(expvar.Get("runstats_test").String()), &point); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+
+	if point.Name != "restarted" {
+		t.Errorf("expected the restarted Runner to publish, got %+v", point)
+	}
+
+	// Names registered by other code are rejected before connecting
+	expvar.NewInt("runstats_test_taken")
+	fake = &fakeClient{}
+
+	_, err = RunCollectorWithClient(&Config{ExpvarName: "runstats_test_taken"}, fake)
+
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Errorf("expected a ConfigError for an already registered expvar, got %v", err)
+	}
+
+	if fake.pings != 0 || len(fake.queries) != 0 {
+		t.Errorf("expected no requests before failing, got %d pings and %d queries", fake.pings, len(fake.queries))
+	}
+}
+
+func TestWriteErrorsAreReported(t *testing.T) {
+	fake := &fakeClient{writeErr: errors.New("unavailable")}
+
+	var mu sync.Mutex
+	var errs []error
+
+	runner, err := RunCollectorWithClient(&Config{
+		CollectionInterval: 10 * time.Millisecond,
+		BatchInterval:      20 * time.Millisecond,
+		OnError: func(err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		},
+	}, fake)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := runner.Stop(); err == nil {
+		t.Errorf("expected the final flush to fail")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(errs) == 0 {
+		t.Errorf("expected write errors to be passed to OnError")
+	}
+}
+
+func TestOnFlush(t *testing.T) {
+	type flush struct {
+		points int
+		err    error
+	}
+	var flushes []flush
+
+	config, _ := (&Config{OnFlush: func(points int, duration time.Duration, err error) {
+		if duration < 0 {
+			t.Errorf("expected a non-negative duration, got %v", duration)
+		}
+		flushes = append(flushes, flush{points, err})
+	}}).init()
+
+	fake := &fakeClient{writeErr: errors.New("unavailable")}
+	r := &runStats{config: config, logger: config.Logger, client: fake}
+	r.points, _ = r.newBatch()
+
+	for i := 0; i < 2; i++ {
+		pt, _ := client.NewPoint("test", nil, map[string]interface{}{"value": i})
+		r.points.AddPoint(pt)
+	}
+
+	if err := r.flush(); err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+
+	fake.writeErr = nil
+	if err := r.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing left to write
+	r.flush()
+
+	if len(flushes) != 2 {
+		t.Fatalf("expected 2 flushes, got %v", flushes)
+	}
+
+	if flushes[0].points != 2 || !errors.Is(flushes[0].err, ErrWriteFailed) {
+		t.Errorf("expected a failed flush of 2 points, got %+v", flushes[0])
+	}
+
+	if flushes[1].points != 2 || flushes[1].err != nil {
+		t.Errorf("expected a successful flush of 2 points, got %+v", flushes[1])
+	}
+}
+
+// blockingClient blocks every write until release is closed.
+type blockingClient struct {
+	fakeClient
+	release chan struct{}
+}
+
+func (b *blockingClient) Write(bp client.BatchPoints) error {
+	<-b.release
+	return b.fakeClient.Write(bp)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	blocking := &blockingClient{release: make(chan struct{})}
+	w := withWriteTimeout(blocking, 10*time.Millisecond)
+
+	bp, _ := client.NewBatchPoints(client.BatchPointsConfig{Database: "test"})
+	if err := w.Write(bp); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected the write to time out, got %v", err)
+	}
+
+	if err := w.Write(bp); err == nil || !strings.Contains(err.Error(), "still running") {
+		t.Errorf("expected the write to fail while the previous one is running, got %v", err)
+	}
+
+	close(blocking.release)
+	time.Sleep(10 * time.Millisecond)
+
+	if err := w.Write(bp); err != nil {
+		t.Errorf("expected the write to succeed, got %v", err)
+	}
+
+	m := withWriteTimeout(multiWriter{{host: "a", writer: &fakeClient{}}}, time.Second).(multiWriter)
+	if _, ok := m[0].writer.(*timeoutWriter); !ok {
+		t.Errorf("expected the host writer to be limited, got %T", m[0].writer)
+	}
+}
+
+func TestMultiWriter(t *testing.T) {
+	ok, failing := &fakeClient{}, &fakeClient{writeErr: errors.New("unavailable")}
+
+	var reported []error
+	onError := func(err error) { reported = append(reported, err) }
+
+	w := multiWriter{
+		{host: "a", writer: ok, onError: onError},
+		{host: "b", writer: failing, onError: onError},
+	}
+
+	bp, _ := client.NewBatchPoints(client.BatchPointsConfig{Database: "test"})
+	if err := w.Write(bp); err != nil {
+		t.Fatalf("expected write to succeed while one host is available, got %v", err)
+	}
+
+	if len(ok.batches) != 1 {
+		t.Errorf("expected the available host to receive the batch, got %d batches", len(ok.batches))
+	}
+
+	if len(reported) != 1 || !strings.Contains(reported[0].Error(), "host b") {
+		t.Errorf("expected failure of host b to be reported, got %v", reported)
+	}
+
+	ok.writeErr = errors.New("unavailable")
+	reported = nil
+
+	err := w.Write(bp)
+	if err == nil || !strings.Contains(err.Error(), "host a") || !strings.Contains(err.Error(), "host b") {
+		t.Errorf("expected write to fail with the failures of all hosts, got %v", err)
+	}
+
+	if len(reported) != 0 {
+		t.Errorf("expected the failures to only be returned, got %v", reported)
+	}
+}
+
+func TestFieldFilter(t *testing.T) {
+	if _, err := (&Config{FieldFilter: []string{"cpu.unknown"}}).init(); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+
+	if _, err := (&Config{FieldFilter: []string{"cpu.goroutines"}}).init(); err != nil {
+		t.Errorf("expected a known field to be accepted, got %v", err)
+	}
+}
+
+func TestCreateRetentionPolicy(t *testing.T) {
+	fake := &fakeClient{}
+	config, err := (&Config{
+		Database:                "test",
+		RetentionPolicy:         "week",
+		CreateRetentionPolicy:   true,
+		RetentionPolicyDuration: 7 * 24 * time.Hour,
+	}).init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := prepare(context.Background(), config, fake); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.queries) != 2 || fake.queries[1] != `CREATE RETENTION POLICY "week" ON "test" DURATION 604800s REPLICATION 1` {
+		t.Errorf("expected the retention policy to be created, got queries %v", fake.queries)
+	}
+
+	logger := &recordingLogger{}
+	config.Logger = logger
+	fake = &fakeClient{queryErr: errors.New("retention policy already exists")}
+	config.SkipDatabaseCreation = true
+	if err := prepare(context.Background(), config, fake); err != nil {
+		t.Errorf("expected a failed creation not to be fatal, got %v", err)
+	}
+	if msgs := logger.logged(); len(msgs) != 1 || !strings.Contains(msgs[0], "failed to create retention policy") {
+		t.Errorf("expected the failure to be logged, got %q", msgs)
+	}
+
+	for _, invalid := range []*Config{
+		{CreateRetentionPolicy: true},
+		{CreateRetentionPolicy: true, RetentionPolicy: "rp", RetentionPolicyDuration: time.Minute},
+		{CreateRetentionPolicy: true, RetentionPolicy: "rp", RetentionPolicyReplication: -1},
+	} {
+		if _, err := invalid.init(); err == nil {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+}
+
+func TestConfigError(t *testing.T) {
+	_, err := (&Config{
+		Precision:          "h",
+		UseUDP:             true,
+		TLSCertFile:        "cert.pem",
+		CollectionInterval: -time.Second,
+		Backpressure:       BackpressurePolicy(7),
+	}).init()
+
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("expected a ConfigError, got %v", err)
+	}
+
+	exp := []string{
+		`invalid precision "h", must be one of ns, us, ms or s`,
+		"UseUDP cannot be combined with TLS, UDP writes are not encrypted",
+		"TLSCertFile and TLSKeyFile must be set together",
+		"invalid CollectionInterval -1s, must not be negative",
+		"invalid backpressure policy 7",
+	}
+	if !reflect.DeepEqual(configErr.Problems, exp) {
+		t.Errorf("unexpected problems:\ngot: %q\nexp: %q", configErr.Problems, exp)
+	}
+	if !strings.HasPrefix(err.Error(), "invalid config: invalid precision") {
+		t.Errorf("unexpected message %s", err)
+	}
+
+	if _, err := RunCollector(&Config{UseUDP: true, V2: &V2Config{}}); !errors.As(err, &configErr) {
+		t.Errorf("expected RunCollector to fail with a ConfigError, got %v", err)
+	}
+}
+
+func TestDisableMemWithoutGc(t *testing.T) {
+	logger := &recordingLogger{}
+	config, err := (&Config{DisableMem: true, Logger: logger}).init()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !config.DisableGc {
+		t.Error("expected DisableMem to disable the GC statistics")
+	}
+
+	if msgs := logger.logged(); len(msgs) != 1 || !strings.Contains(msgs[0], "DisableGc") {
+		t.Errorf("expected a warning, got %q", msgs)
+	}
+
+	logger = &recordingLogger{}
+	if _, err := (&Config{DisableMem: true, DisableGc: true, Logger: logger}).init(); err != nil || len(logger.logged()) != 0 {
+		t.Errorf("expected DisableMem with DisableGc to be accepted silently, got %v and %q", err, logger.logged())
+	}
+}
+
+func TestPrecision(t *testing.T) {
+	if _, err := (&Config{Precision: "h"}).init(); err == nil {
+		t.Error("expected an error for an invalid precision")
+	}
+
+	fake := &fakeClient{}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 123456789, time.UTC)
+
+	runner, err := RunCollectorWithClient(&Config{
+		Precision:          "ms",
+		Clock:              func() time.Time { return now },
+		CollectionInterval: time.Hour,
+		BatchInterval:      time.Hour,
+	}, fake)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.batches) != 1 || len(fake.batches[0].Points()) != 1 {
+		t.Fatalf("expected a single point to be flushed, got %d points", fake.points())
+	}
+
+	if ts := fake.batches[0].Points()[0].Time(); !ts.Equal(now.Truncate(time.Millisecond)) {
+		t.Errorf("expected timestamp truncated to milliseconds, got %v", ts)
+	}
+}
+
+func TestMeasurementTemplate(t *testing.T) {
+	config, err := (&Config{
+		Measurement:     "runtime.{{.Service}}.{{.Goos}}.{{.PID}}",
+		MeasurementVars: map[string]string{"Service": "api"},
+	}).init()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := "runtime.api." + runtime.GOOS + "." + strconv.Itoa(os.Getpid()); config.Measurement != exp {
+		t.Errorf("expected measurement (%s) got (%s)", exp, config.Measurement)
+	}
+
+	if config, _ := (&Config{Measurement: "runtime.{literal}"}).init(); config.Measurement != "runtime.{literal}" {
+		t.Errorf("expected literal measurement, got (%s)", config.Measurement)
+	}
+
+	if _, err := (&Config{Measurement: "runtime.{{.Service}}"}).init(); err == nil {
+		t.Error("expected an error for an undefined variable")
+	}
+
+	if _, err := (&Config{Measurement: "runtime.{{.Host"}).init(); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+}
+
+func TestWriteConsistency(t *testing.T) {
+	if _, err := (&Config{WriteConsistency: "most"}).init(); err == nil {
+		t.Error("expected an error for an invalid write consistency")
+	}
+
+	config, err := (&Config{WriteConsistency: "quorum"}).init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &runStats{config: config, logger: config.Logger}
+	if bp, _ := r.newBatch(); bp.WriteConsistency() != "quorum" {
+		t.Errorf("expected write consistency (quorum) got (%s)", bp.WriteConsistency())
+	}
+}
+
+func TestByteUnit(t *testing.T) {
+	if _, err := (&Config{ByteUnit: "tb"}).init(); err == nil {
+		t.Error("expected an error for an invalid byte unit")
+	}
+
+	values := map[string]interface{}{
+		"mem.heap.alloc":   int64(3 << 20),
+		"mem.alloc_rate":   float64(1 << 20),
+		"mem.heap.objects": int64(10),
+		"cpu.goroutines":   int64(2),
+	}
+	scaleBytes(values, byteUnits["mb"])
+
+	exp := map[string]interface{}{
+		"mem.heap.alloc":   float64(3),
+		"mem.alloc_rate":   float64(1),
+		"mem.heap.objects": int64(10),
+		"cpu.goroutines":   int64(2),
+	}
+	for key, value := range exp {
+		if values[key] != value {
+			t.Errorf("expected %s (%v) got (%v)", key, value, values[key])
+		}
+	}
+}
+
+func TestRoundFloats(t *testing.T) {
+	values := map[string]interface{}{
+		"mem.gc.cpu_fraction": 0.000123456,
+		"mem.heap.alloc":      float64(12.3456),
+		"cpu.goroutines":      int64(2),
+	}
+	roundFloats(values, 4)
+
+	exp := map[string]interface{}{
+		"mem.gc.cpu_fraction": 0.0001,
+		"mem.heap.alloc":      12.3456,
+		"cpu.goroutines":      int64(2),
+	}
+	for key, value := range exp {
+		if values[key] != value {
+			t.Errorf("expected %s (%v) got (%v)", key, value, values[key])
+		}
+	}
+}
+
+func TestFieldsInterceptor(t *testing.T) {
+	fake := &fakeClient{}
+
+	runner, err := RunCollectorWithClient(&Config{
+		CollectionInterval: time.Hour,
+		BatchInterval:      time.Hour,
+		FieldsInterceptor: func(fields collector.Fields) collector.Fields {
+			fields.HeapAlloc /= 1024 * 1024 * 1024 * 1024
+			fields.NumGoroutine = 0
+			return fields
+		},
+	}, fake)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.points() != 1 {
+		t.Fatalf("expected a single point, got %d", fake.points())
+	}
+
+	values, err := fake.batches[0].Points()[0].Fields()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"cpu.goroutines", "mem.heap.alloc"} {
+		if _, ok := values[key]; ok {
+			t.Errorf("expected zeroed key (%s) to be dropped", key)
+		}
+	}
+
+	if _, ok := values["cpu.count"]; !ok {
+		t.Error("expected key (cpu.count) to be kept")
+	}
+}
+
+func TestMixedFieldTypes(t *testing.T) {
+	fake := &fakeClient{}
+
+	runner, err := RunCollectorWithClient(&Config{
+		CollectionInterval: time.Hour,
+		BatchInterval:      time.Hour,
+		FieldsInterceptor: func(fields collector.Fields) collector.Fields {
+			fields.GCCPUFraction = 0.000123456789
+			fields.Extra = map[string]interface{}{"app.release": "v1.2.3", "app.ratio": 2.0}
+			return fields
+		},
+	}, fake)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	values, _ := fake.batches[0].Points()[0].Fields()
+	for key, exp := range map[string]interface{}{
+		"mem.gc.cpu_fraction": 0.000123456789,
+		"app.release":         "v1.2.3",
+		"app.ratio":           2.0,
+	} {
+		if values[key] != exp {
+			t.Errorf("expected %s (%v) got (%v)", key, exp, values[key])
+		}
+	}
+
+	if _, ok := values["cpu.goroutines"].(int64); !ok {
+		t.Errorf("expected cpu.goroutines to stay an int64, got %T", values["cpu.goroutines"])
+	}
+}
+
+func TestStartupRetries(t *testing.T) {
+	fake := &fakeClient{pingFailures: 2}
+	config, _ := (&Config{StartupRetries: 2, StartupRetryInterval: time.Millisecond}).init()
+
+	if err := prepare(context.Background(), config, fake); err != nil {
+		t.Fatalf("expected ping to succeed on the last retry, got %v", err)
+	}
+
+	fake = &fakeClient{pingFailures: 3}
+	if err := prepare(context.Background(), config, fake); err == nil {
+		t.Error("expected an error after the retries are exhausted")
+	}
+
+	if fake.pings != 3 {
+		t.Errorf("expected 3 pings, got %d", fake.pings)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	fake = &fakeClient{}
+	if err := prepare(ctx, config, fake); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the canceled context to stop the retries, got %v", err)
+	}
+}
+
+func TestErrorClasses(t *testing.T) {
+	config, _ := (&Config{}).init()
+
+	if err := prepare(context.Background(), config, &fakeClient{pingFailures: 1}); !errors.Is(err, ErrPingFailed) {
+		t.Errorf("expected ErrPingFailed, got %v", err)
+	}
+
+	pt, _ := client.NewPoint("test", nil, map[string]interface{}{"value": 1})
+
+	r := &runStats{config: config, logger: config.Logger, client: &fakeClient{writeErr: errors.New("timeout")}}
+	r.points, _ = r.newBatch()
+	r.points.AddPoint(pt)
+
+	err := r.flush()
+	if !errors.Is(err, ErrWriteFailed) || errors.Is(err, ErrAuth) {
+		t.Errorf("expected ErrWriteFailed, got %v", err)
+	}
+
+	r.client = &fakeClient{writeErr: errors.New("authorization failed")}
+	if err := r.flush(); !errors.Is(err, ErrAuth) {
+		t.Errorf("expected ErrAuth, got %v", err)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "http://influxdb:8086/write", nil)
+
+	proxy, err := (&Config{HTTPProxy: "http://proxy:3128"}).proxy()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u, err := proxy(req); err != nil || u.String() != "http://proxy:3128" {
+		t.Errorf("expected the configured proxy, got %v (%v)", u, err)
+	}
+
+	if _, err := (&Config{HTTPProxy: "://invalid"}).proxy(); err == nil {
+		t.Error("expected an error for an invalid proxy")
+	}
+}
+
+func TestMonotonicTimestamps(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := []time.Time{now, now.Add(time.Second), now.Add(-time.Minute), now.Add(time.Second)}
+
+	config, _ := (&Config{Precision: "ms", MonotonicTimestamps: true, Clock: func() time.Time {
+		t := clock[0]
+		clock = clock[1:]
+		return t
+	}}).init()
+
+	r := &runStats{config: config}
+
+	var prev time.Time
+	for i := 0; i < 4; i++ {
+		ts := r.timestamp()
+		if !ts.After(prev) {
+			t.Errorf("expected timestamp %d (%v) after %v", i, ts, prev)
+		}
+		prev = ts
+	}
+}
+
+// recordingLogger records the messages logged with Println.
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Println(v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.messages = append(l.messages, fmt.Sprint(v...))
+}
+
+func (l *recordingLogger) Fatalln(v ...interface{}) { l.Println(v...) }
+
+func (l *recordingLogger) logged() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]string(nil), l.messages...)
+}
+
+func TestRateLimitedLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	limited := NewRateLimitedLogger(logger, 20*time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		limited.Println("write failed")
+	}
+	limited.Println("other")
+
+	if msgs := logger.logged(); len(msgs) != 2 || msgs[0] != "write failed" || msgs[1] != "other" {
+		t.Fatalf("expected every message to be logged once, got %q", msgs)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	msgs := logger.logged()
+	if len(msgs) != 3 || msgs[2] != "message repeated 4 more times in 20ms: write failed" {
+		t.Fatalf("expected a summary of the suppressed messages, got %q", msgs)
+	}
+
+	limited.Println("write failed")
+	if msgs := logger.logged(); len(msgs) != 4 || msgs[3] != "write failed" {
+		t.Errorf("expected a new window to log the message again, got %q", msgs)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	fake := &fakeClient{}
+	logger := &recordingLogger{}
+
+	runner, err := RunCollectorWithClient(&Config{
+		DryRun:             true,
+		Measurement:        "test",
+		Logger:             logger,
+		CollectionInterval: time.Hour,
+		BatchInterval:      time.Hour,
+	}, fake)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.pings != 0 || len(fake.queries) != 0 || fake.points() != 0 {
+		t.Errorf("expected the client to be unused, got %d pings, queries %v and %d points", fake.pings, fake.queries, fake.points())
+	}
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+
+	var lines int
+	for _, msg := range logger.messages {
+		if strings.HasPrefix(msg, "test,") && strings.Contains(msg, "cpu.goroutines=") {
+			lines++
+		}
+	}
+
+	if lines != 1 {
+		t.Errorf("expected a single line protocol point to be logged, got %q", logger.messages)
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	logger := &recordingLogger{}
+	var flushed int
+
+	err := RunOnce(&Config{
+		DryRun:      true,
+		Measurement: "test",
+		Logger:      logger,
+		OnFlush: func(points int, elapsed time.Duration, err error) {
+			flushed += points
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if flushed != 1 {
+		t.Errorf("expected a single point to be flushed, got %d", flushed)
+	}
+
+	if msgs := logger.logged(); len(msgs) != 1 || !strings.HasPrefix(msgs[0], "test,") {
+		t.Errorf("expected a single line protocol point to be logged, got %q", msgs)
+	}
+
+	var configErr *ConfigError
+	if err := RunOnce(&Config{Precision: "h"}); !errors.As(err, &configErr) {
+		t.Errorf("expected a ConfigError, got %v", err)
+	}
+}
+
+func TestGzipClient(t *testing.T) {
+	var encoding, query, user, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding, query = r.Header.Get("Content-Encoding"), r.URL.RawQuery
+		user, _, _ = r.BasicAuth()
+
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("expected a gzip body: %v", err)
+			return
+		}
+		b, _ := io.ReadAll(zr)
+		body = string(b)
+
+		if strings.Contains(body, "fail") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"partial write"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	c := newGzipClient(nil, server.URL, &Config{Username: "admin", Password: "secret"}, nil, nil)
+	bp, _ := client.NewBatchPoints(client.BatchPointsConfig{Database: "test", Precision: "s", RetentionPolicy: "week"})
+	pt, _ := client.NewPoint("test", nil, map[string]interface{}{"value": 1})
+	bp.AddPoint(pt)
+
+	if err := c.Write(bp); err != nil {
+		t.Fatal(err)
+	}
+
+	if encoding != "gzip" {
+		t.Errorf("expected Content-Encoding gzip, got %q", encoding)
+	}
+	if query != "db=test&precision=s&rp=week" || user != "admin" {
+		t.Errorf("unexpected query %q or user %q", query, user)
+	}
+	if !strings.HasPrefix(body, "test value=1") || !strings.HasSuffix(body, "\n") {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	pt, _ = client.NewPoint("fail", nil, map[string]interface{}{"value": 1})
+	bp.AddPoint(pt)
+	if err := c.Write(bp); err == nil || err.Error() != "partial write" {
+		t.Errorf("expected the error of the server, got %v", err)
+	}
+}
+
+func TestSocketPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telegraf.sock")
+
+	listen := func() (net.Listener, chan string) {
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := make(chan string, 16)
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+
+				go func() {
+					defer conn.Close()
+
+					scanner := bufio.NewScanner(conn)
+					scanner.Buffer(nil, 1<<20)
+					for scanner.Scan() {
+						lines <- scanner.Text()
+					}
+				}()
+			}
+		}()
+
+		return l, lines
+	}
+
+	l, lines := listen()
+
+	runner, err := RunCollector(&Config{
+		SocketPath:         path,
+		Measurement:        "test",
+		CollectionInterval: time.Hour,
+		BatchInterval:      time.Hour,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := runner.closer.(*socketWriter)
+	bp, _ := runner.stats.newBatch()
+	pt, _ := client.NewPoint("test", nil, map[string]interface{}{"value": 1})
+	bp.AddPoint(pt)
+
+	if err := w.Write(bp); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case line := <-lines:
+		if !strings.HasPrefix(line, "test value=1") {
+			t.Errorf("unexpected line %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a line to be received")
+	}
+
+	// Restart the listener, the next write reconnects.
+	l.Close()
+	w.mu.Lock()
+	w.conn.Close()
+	w.mu.Unlock()
+	l, lines = listen()
+	defer l.Close()
+
+	if err := w.Write(bp); err != nil {
+		t.Fatalf("expected the write to reconnect, got %v", err)
+	}
+
+	select {
+	case <-lines:
+	case <-time.After(time.Second):
+		t.Fatal("expected a line to be received after reconnecting")
+	}
+
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RunCollector(&Config{SocketPath: filepath.Join(t.TempDir(), "missing.sock")}); !errors.Is(err, ErrPingFailed) {
+		t.Errorf("expected ErrPingFailed for a missing socket, got %v", err)
+	}
+}
+
+// failingConn accepts the first n bytes written and then fails.
+type failingConn struct {
+	net.Conn
+	n       int
+	written []byte
+}
+
+func (c *failingConn) Write(b []byte) (int, error) {
+	n := len(b)
+	if n > c.n {
+		n = c.n
+	}
+	c.written = append(c.written, b[:n]...)
+	c.n -= n
+
+	if n < len(b) {
+		return n, errors.New("broken pipe")
+	}
+	return n, nil
+}
+
+func (c *failingConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *failingConn) Close() error                     { return nil }
+
+func TestSocketWriterResumesAfterPartialWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telegraf.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		received <- string(b)
+	}()
+
+	bp, _ := client.NewBatchPoints(client.BatchPointsConfig{})
+	for i := 1; i <= 3; i++ {
+		pt, _ := client.NewPoint("test", nil, map[string]interface{}{"value": i})
+		bp.AddPoint(pt)
+	}
+	first := len(bp.Points()[0].String()) + 1
+
+	// The first line and part of the second reach the failing connection
+	failing := &failingConn{n: first + 3}
+	w := &socketWriter{path: path, conn: failing}
+
+	if err := w.Write(bp); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	select {
+	case lines := <-received:
+		if !strings.HasPrefix(lines, "test value=2") || strings.Count(lines, "\n") != 2 {
+			t.Errorf("expected the write to resume at the interrupted line, got %q", lines)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the lines to be received")
+	}
+}
+
+func TestStopClosesUDPClient(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	runner, err := RunCollector(&Config{
+		UseUDP:             true,
+		Host:               conn.LocalAddr().String(),
+		CollectionInterval: time.Hour,
+		BatchInterval:      time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closers, ok := runner.closer.(multiCloser)
+	if !ok || len(closers) != 1 {
+		t.Fatalf("expected the UDP writer to be closed on stop, got closer %T", runner.closer)
+	}
+
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	bp, _ := client.NewBatchPoints(client.BatchPointsConfig{})
+	pt, _ := client.NewPoint("test", nil, map[string]interface{}{"value": 1})
+	bp.AddPoint(pt)
+
+	if err := closers[0].(*udpWriter).client.Write(bp); err == nil {
+		t.Error("expected the UDP client to be closed")
+	}
+}
+
+func TestUDPWriterSplitsPayloads(t *testing.T) {
+	fake := &fakeClient{}
+	w := &udpWriter{client: fake, payloadSize: 256}
+
+	bp, _ := client.NewBatchPoints(client.BatchPointsConfig{Database: "test"})
+	exp := map[string]interface{}{}
+	for i := 0; i < 3; i++ {
+		fields := collector.New(nil).OneOff()
+		values := fields.Values()
+		for key, value := range values {
+			exp[strconv.Itoa(i)+key] = value
+		}
+
+		pt, _ := client.NewPoint("test", map[string]string{"point": strconv.Itoa(i)}, values, time.Unix(int64(i), 0))
+		bp.AddPoint(pt)
+	}
+
+	if err := w.Write(bp); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.batches) < 2 {
+		t.Fatalf("expected the batch to be split, got %d batches", len(fake.batches))
+	}
+
+	got := map[string]interface{}{}
+	for _, batch := range fake.batches {
+		size := 0
+		for _, pt := range batch.Points() {
+			size += len(pt.String()) + 1
+
+			fields, _ := pt.Fields()
+			for key, value := range fields {
+				got[pt.Tags()["point"]+key] = value
+			}
+
+			if i, _ := strconv.Atoi(pt.Tags()["point"]); !pt.Time().Equal(time.Unix(int64(i), 0)) {
+				t.Errorf("expected the timestamp of the split point to be kept, got %v", pt.Time())
+			}
+		}
+
+		if size > w.payloadSize {
+			t.Errorf("expected payloads of at most %d bytes, got %d", w.payloadSize, size)
+		}
+	}
+
+	if len(got) != len(exp) {
+		t.Errorf("expected all %d fields to be written, got %d", len(exp), len(got))
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("RUNSTATS_ADDR", "influxdb:8086")
+	t.Setenv("RUNSTATS_DATABASE", "metrics")
+	t.Setenv("RUNSTATS_INTERVAL", "5s")
+	t.Setenv("RUNSTATS_GZIP", "true")
+	t.Setenv("RUNSTATS_HOSTS", "a:8086, b:8086")
+	t.Setenv("RUNSTATS_TAGS", "env=prod,region=eu")
+	t.Setenv("RUNSTATS_STARTUP_RETRIES", "3")
+	t.Setenv("RUNSTATS_USERNAME", "")
+	t.Setenv("RUNSTATS_BACKPRESSURE", "drop_oldest")
+	t.Setenv("RUNSTATS_V2_BUCKET", "runtime")
+	t.Setenv("RUNSTATS_ENABLE_SIZE_CLASSES", "1")
+	t.Setenv("RUNSTATS_POINT_BUFFER_SIZE", "16")
+
+	config, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Host != "influxdb:8086" || config.Database != "metrics" || config.CollectionInterval != 5*time.Second ||
+		!config.GzipEnabled || config.StartupRetries != 3 || config.Username != "" {
+		t.Errorf("unexpected config %+v", config)
+	}
+
+	if len(config.Hosts) != 2 || config.Hosts[1] != "b:8086" {
+		t.Errorf("unexpected hosts %v", config.Hosts)
+	}
+
+	if config.Tags["env"] != "prod" || config.Tags["region"] != "eu" {
+		t.Errorf("unexpected tags %v", config.Tags)
+	}
+
+	if config.Backpressure != DropOldest || config.V2 == nil || config.V2.Bucket != "runtime" ||
+		!config.EnableSizeClasses || config.PointBufferSize != 16 {
+		t.Errorf("unexpected config %+v", config)
+	}
+
+	for name, value := range map[string]string{
+		"RUNSTATS_INTERVAL":     "often",
+		"RUNSTATS_GZIP":         "maybe",
+		"RUNSTATS_TAGS":         "env",
+		"RUNSTATS_BACKPRESSURE": "drop_all",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+
+			if _, err := ConfigFromEnv(); err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("expected an error naming %s, got %v", name, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package runstats
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/pkg/errors"
+)
+
+// Timeout of dialing the Unix socket and of every write to it
+const socketTimeout = 5 * time.Second
+
+// Streams batches in line protocol to a Unix domain socket, e.g. the
+// socket_listener input of Telegraf. The connection is re-established when a
+// write fails, e.g. because the listener restarted. Delivery is at least once:
+// the lines written before the failure are not sent again, but the line the
+// failure interrupted is sent again in full on the new connection.
+type socketWriter struct {
+	path string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// Connect to the Unix socket at config.SocketPath
+func connectSocket(ctx context.Context, config *Config) (*socketWriter, error) {
+	w := &socketWriter{path: config.SocketPath}
+
+	err := pingWithRetries(ctx, config, func(ctx context.Context) error {
+		return w.dial(ctx)
+	})
+
+	if err != nil {
+		return nil, classify(ErrPingFailed, errors.Wrapf(err, "failed to connect to socket %s", config.SocketPath))
+	}
+
+	return w, nil
+}
+
+func (w *socketWriter) dial(ctx context.Context) error {
+	conn, err := (&net.Dialer{Timeout: socketTimeout}).DialContext(ctx, "unix", w.path)
+
+	if err != nil {
+		return err
+	}
+
+	w.conn = conn
+	return nil
+}
+
+func (w *socketWriter) Write(bp client.BatchPoints) error {
+	var buf []byte
+	for _, pt := range bp.Points() {
+		buf = append(buf, pt.PrecisionString(bp.Precision())...)
+		buf = append(buf, '\n')
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n, err := w.write(buf)
+
+	if err != nil {
+		// The listener may have restarted, retry once on a new connection from
+		// the start of the first line that was not written completely.
+		_, err = w.write(buf[bytes.LastIndexByte(buf[:n], '\n')+1:])
+	}
+
+	return err
+}
+
+// Write buf to the connection, dialing a new one if there is none, and return
+// the number of bytes written. The connection is closed when the write fails.
+func (w *socketWriter) write(buf []byte) (int, error) {
+	if w.conn == nil {
+		if err := w.dial(context.Background()); err != nil {
+			return 0, errors.Wrapf(err, "failed to connect to socket %s", w.path)
+		}
+	}
+
+	w.conn.SetWriteDeadline(time.Now().Add(socketTimeout))
+
+	n, err := w.conn.Write(buf)
+
+	if err != nil {
+		w.conn.Close()
+		w.conn = nil
+
+		return n, errors.Wrapf(err, "failed to write to socket %s", w.path)
+	}
+
+	return n, nil
+}
+
+// Close the connection
+func (w *socketWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
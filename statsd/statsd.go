@@ -0,0 +1,117 @@
+package statsd
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+// maxPacketSize keeps each datagram below the typical ethernet MTU.
+const maxPacketSize = 1432
+
+// Client sends collected statistics as StatsD gauges over UDP.
+//
+//	package main
+//
+//	import (
+//	   "github.com/tevjef/go-runtime-metrics/collector"
+//	   "github.com/tevjef/go-runtime-metrics/statsd"
+//	)
+//
+//	func main {
+//	    client, err := statsd.New("localhost:8125", "myapp.", "env:prod")
+//	    if err != nil {
+//	        // handle error
+//	    }
+//	    go collector.New(client.Send).Run()
+//	}
+type Client struct {
+	conn   net.Conn
+	prefix string
+	tags   string
+}
+
+// New creates a Client which writes to the StatsD server at addr. prefix is
+// prepended as-is to every metric name. tags are optional DogStatsD tags, e.g.
+// "env:prod", that are attached to every gauge.
+func New(addr, prefix string, tags ...string) (*Client, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:   conn,
+		prefix: prefix,
+	}
+
+	if len(tags) > 0 {
+		c.tags = "|#" + strings.Join(tags, ",")
+	}
+
+	return c, nil
+}
+
+// Send writes every value of fields as a gauge named after its key. It matches
+// the signature of collector.FieldsFunc. Write errors are dropped as delivery
+// is not guaranteed with StatsD.
+func (c *Client) Send(fields collector.Fields) {
+	values := fields.Values()
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf, line bytes.Buffer
+	for _, key := range keys {
+		line.Reset()
+		if !c.appendGauge(&line, key, values[key]) {
+			continue
+		}
+
+		if buf.Len() > 0 && buf.Len()+1+line.Len() > maxPacketSize {
+			c.conn.Write(buf.Bytes())
+			buf.Reset()
+		}
+
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.Write(line.Bytes())
+	}
+
+	if buf.Len() > 0 {
+		c.conn.Write(buf.Bytes())
+	}
+}
+
+// Close closes the underlying connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) appendGauge(buf *bytes.Buffer, key string, value interface{}) bool {
+	var formatted string
+	switch v := value.(type) {
+	case int64:
+		formatted = strconv.FormatInt(v, 10)
+	case float64:
+		formatted = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return false
+	}
+
+	buf.WriteString(c.prefix)
+	buf.WriteString(key)
+	buf.WriteByte(':')
+	buf.WriteString(formatted)
+	buf.WriteString("|g")
+	buf.WriteString(c.tags)
+	return true
+}
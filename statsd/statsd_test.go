@@ -0,0 +1,61 @@
+package statsd
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tevjef/go-runtime-metrics/collector"
+)
+
+func TestSend(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client, err := New(conn.LocalAddr().String(), "test.", "env:ci", "region:local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Send(collector.New(nil).OneOff())
+
+	var lines []string
+	buf := make([]byte, maxPacketSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		if n > maxPacketSize {
+			t.Errorf("packet exceeds max size: %d", n)
+		}
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+
+	expKeys := []string{
+		"test.cpu.goroutines:",
+		"test.mem.lookups:",
+		"test.mem.gc.count:",
+	}
+
+	for _, expKey := range expKeys {
+		found := false
+		for _, line := range lines {
+			if strings.HasPrefix(line, expKey) {
+				found = true
+				if !strings.HasSuffix(line, "|g|#env:ci,region:local") {
+					t.Errorf("unexpected gauge format: %s", line)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("expected key (%s) not found", expKey)
+		}
+	}
+}
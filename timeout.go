@@ -0,0 +1,79 @@
+package runstats
+
+import (
+	"context"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/pkg/errors"
+)
+
+// Writers accepting a context, which limits the write to WriteTimeout
+type contextWriter interface {
+	WriteContext(ctx context.Context, bp client.BatchPoints) error
+}
+
+// Limits every write to timeout. Writers without a context, such as the
+// InfluxDB 1.x client, write in a goroutine which is abandoned on timeout.
+// Abandoned writes may still complete, but InfluxDB stores the retried points
+// with the same timestamps only once. A new write fails immediately while an
+// abandoned one is still running, so a hanging server does not pile up
+// goroutines.
+type timeoutWriter struct {
+	writer  batchWriter
+	timeout time.Duration
+	running chan struct{}
+}
+
+// Limit the writes of writer, or of every host of a multiWriter, to timeout
+func withWriteTimeout(writer batchWriter, timeout time.Duration) batchWriter {
+	if m, ok := writer.(multiWriter); ok {
+		limited := make(multiWriter, len(m))
+		for i, w := range m {
+			w.writer = newTimeoutWriter(w.writer, timeout)
+			limited[i] = w
+		}
+		return limited
+	}
+
+	return newTimeoutWriter(writer, timeout)
+}
+
+func newTimeoutWriter(writer batchWriter, timeout time.Duration) *timeoutWriter {
+	return &timeoutWriter{
+		writer:  writer,
+		timeout: timeout,
+		running: make(chan struct{}, 1),
+	}
+}
+
+func (w *timeoutWriter) Write(bp client.BatchPoints) error {
+	if cw, ok := w.writer.(contextWriter); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+		defer cancel()
+
+		return cw.WriteContext(ctx, bp)
+	}
+
+	select {
+	case w.running <- struct{}{}:
+	default:
+		return errors.New("previous write timed out and is still running")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() { <-w.running }()
+		done <- w.writer.Write(bp)
+	}()
+
+	timer := time.NewTimer(w.timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errors.Errorf("write timed out after %v", w.timeout)
+	}
+}
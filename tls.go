@@ -0,0 +1,60 @@
+package runstats
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Build the tls.Config used to connect to InfluxDB. It is nil when neither
+// TLSConfig nor any of the TLS files are set
+func (config *Config) tlsConfig() (*tls.Config, error) {
+	if config.TLSConfig != nil {
+		return config.TLSConfig, nil
+	}
+
+	if config.TLSCertFile == "" && config.TLSKeyFile == "" && config.TLSCAFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if config.TLSCertFile != "" || config.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load TLS client certificate")
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if config.TLSCAFile != "" {
+		pem, err := os.ReadFile(config.TLSCAFile)
+
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read TLS CA file")
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in TLS CA file %q", config.TLSCAFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// Address of the InfluxDB host including the scheme
+func addr(host string, tlsConfig *tls.Config) string {
+	if tlsConfig != nil {
+		return "https://" + host
+	}
+
+	return "http://" + host
+}
@@ -0,0 +1,117 @@
+package runstats
+
+import (
+	"io"
+	"sort"
+
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/pkg/errors"
+)
+
+// Writes batches over UDP in payloads of at most payloadSize bytes. Points too
+// large for a single payload, which every point with all statistics is
+// compared to the default payload size, are split into several points with the
+// same tags and timestamp, each holding a subset of the fields.
+type udpWriter struct {
+	client      batchWriter
+	payloadSize int
+}
+
+// Create the UDP client for host. UDP neither supports pings nor queries, so
+// the database must exist already.
+func connectUDP(config *Config, host string) (*udpWriter, error) {
+	clnt, err := client.NewUDPClient(client.UDPConfig{
+		Addr:        host,
+		PayloadSize: config.PayloadSize,
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create influxdb udp client")
+	}
+
+	return &udpWriter{client: clnt, payloadSize: config.PayloadSize}, nil
+}
+
+func (w *udpWriter) Write(bp client.BatchPoints) error {
+	config := client.BatchPointsConfig{
+		Database:         bp.Database(),
+		Precision:        bp.Precision(),
+		RetentionPolicy:  bp.RetentionPolicy(),
+		WriteConsistency: bp.WriteConsistency(),
+	}
+
+	var batches []client.BatchPoints
+	var batch client.BatchPoints
+	size := 0
+
+	for _, pt := range bp.Points() {
+		for _, pt := range splitPoint(pt, bp.Precision(), w.payloadSize) {
+			n := len(pt.PrecisionString(bp.Precision())) + 1
+
+			if batch == nil || size+n > w.payloadSize {
+				var err error
+				if batch, err = client.NewBatchPoints(config); err != nil {
+					return err
+				}
+
+				batches = append(batches, batch)
+				size = 0
+			}
+
+			batch.AddPoint(pt)
+			size += n
+		}
+	}
+
+	for _, batch := range batches {
+		if err := w.client.Write(batch); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Split pt in halves by field keys until every point fits into payloadSize
+// including its newline. A point with a single field is returned as is, even
+// if it does not fit.
+func splitPoint(pt *client.Point, precision string, payloadSize int) []*client.Point {
+	fields, err := pt.Fields()
+
+	if err != nil || len(fields) <= 1 || len(pt.PrecisionString(precision))+1 <= payloadSize {
+		return []*client.Point{pt}
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var points []*client.Point
+	for _, half := range [][]string{keys[:len(keys)/2], keys[len(keys)/2:]} {
+		subset := make(map[string]interface{}, len(half))
+		for _, key := range half {
+			subset[key] = fields[key]
+		}
+
+		split, err := client.NewPoint(pt.Name(), pt.Tags(), subset, pt.Time())
+
+		if err != nil {
+			return []*client.Point{pt}
+		}
+
+		points = append(points, splitPoint(split, precision, payloadSize)...)
+	}
+
+	return points
+}
+
+// Close closes the UDP socket of the client
+func (w *udpWriter) Close() error {
+	if c, ok := w.client.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
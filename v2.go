@@ -0,0 +1,119 @@
+package runstats
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/pkg/errors"
+)
+
+// Timeout of a request with a custom HTTP client, the default of the client
+const v2RequestTimeout = 20 * time.Second
+
+// V2Config configures writing to InfluxDB 2.x.
+type V2Config struct {
+	// Organization owning the bucket.
+	Org string
+
+	// Bucket to write points to. It is not auto created.
+	Bucket string
+
+	// API token with write permission on the bucket.
+	Token string
+}
+
+// Writes batches to InfluxDB 2.x through the blocking write API
+type v2Writer struct {
+	client influxdb2.Client
+	api    api.WriteAPIBlocking
+}
+
+// Connect to the InfluxDB 2.x host
+func connectV2(ctx context.Context, config *Config, host string) (*v2Writer, error) {
+	if config.V2.Org == "" || config.V2.Bucket == "" {
+		return nil, errors.New("influxdb 2.x requires an org and a bucket")
+	}
+
+	tlsConfig, err := config.tlsConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	options := influxdb2.DefaultOptions().
+		SetTLSConfig(tlsConfig).
+		SetPrecision(precisions[config.Precision]).
+		SetUseGZip(config.GzipEnabled)
+
+	// The default client already honors the proxy environment variables.
+	if config.HTTPProxy != "" {
+		proxy, err := config.proxy()
+
+		if err != nil {
+			return nil, err
+		}
+
+		// A custom HTTP client replaces the TLS options, so its transport
+		// carries them.
+		options.SetHTTPClient(&http.Client{
+			Timeout: v2RequestTimeout,
+			Transport: &http.Transport{
+				Proxy:           proxy,
+				TLSClientConfig: tlsConfig,
+			},
+		})
+	}
+
+	clnt := influxdb2.NewClientWithOptions(addr(host, tlsConfig), config.V2.Token, options)
+
+	// Ping InfluxDB to ensure there is a connection
+	err = pingWithRetries(ctx, config, func(ctx context.Context) error {
+		if ok, err := clnt.Ping(ctx); err != nil || !ok {
+			if err == nil {
+				err = errors.New("server is not ready")
+			}
+
+			return err
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		clnt.Close()
+		return nil, classify(ErrPingFailed, errors.Wrap(err, "failed to ping influxdb client"))
+	}
+
+	return &v2Writer{client: clnt, api: clnt.WriteAPIBlocking(config.V2.Org, config.V2.Bucket)}, nil
+}
+
+// Close releases the resources of the client, such as idle connections
+func (w *v2Writer) Close() error {
+	w.client.Close()
+	return nil
+}
+
+func (w *v2Writer) Write(bp client.BatchPoints) error {
+	return w.WriteContext(context.Background(), bp)
+}
+
+func (w *v2Writer) WriteContext(ctx context.Context, bp client.BatchPoints) error {
+	points := make([]*write.Point, 0, len(bp.Points()))
+
+	for _, pt := range bp.Points() {
+		fields, err := pt.Fields()
+
+		if err != nil {
+			return err
+		}
+
+		points = append(points, write.NewPoint(pt.Name(), pt.Tags(), fields, pt.Time()))
+	}
+
+	return w.api.WritePoint(ctx, points...)
+}